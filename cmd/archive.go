@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// archiveVersion is the version of the archive layout written to stack.json.
+const archiveVersion = 1
+
+// noEchoValue is how DescribeStacks reports the value of NoEcho parameters.
+const noEchoValue = "****"
+
+// stackArchive is the definition of a stack saved to stack.json in an
+// archive, next to the template and the resource list. NoEcho parameters are
+// not saved; their names are listed so a restore can ask for them.
+type stackArchive struct {
+	Version               int
+	StackName             string
+	StackID               string
+	Region                string
+	Description           string `json:",omitempty"`
+	Status                types.StackStatus
+	StatusReason          string `json:",omitempty"`
+	Reason                string
+	ArchivedAt            time.Time
+	TemplateFile          string
+	Parameters            []types.Parameter
+	NoEchoParameters      []string `json:",omitempty"`
+	Outputs               []types.Output
+	Tags                  []types.Tag
+	Capabilities          []types.Capability
+	TerminationProtection bool
+	StackPolicy           string   `json:",omitempty"`
+	RoleARN               string   `json:",omitempty"`
+	NotificationARNs      []string `json:",omitempty"`
+}
+
+// stackBackup is the full content of an archive.
+type stackBackup struct {
+	stack     stackArchive
+	template  string
+	resources []types.StackResourceSummary
+}
+
+// defaultArchiveDir is where stacks are archived before deletion.
+func defaultArchiveDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "cfn-archive"
+	}
+	return filepath.Join(home, ".cfn", "archive")
+}
+
+// archivePath returns the directory a stack is archived to: one directory
+// per stack with one timestamped directory per archive.
+func archivePath(dir, stackName string, now time.Time) string {
+	return filepath.Join(dir, stackName, now.UTC().Format("20060102T150405Z"))
+}
+
+// archiveStack saves the template, definition and resources of a stack under
+// dir and returns the directory written to. reason records why the archive
+// was taken.
+func archiveStack(ctx context.Context, client *cloudformation.Client, stackName, dir, reason string) (string, error) {
+	backup, err := captureStack(ctx, client, stackName, reason)
+	if err != nil {
+		return "", err
+	}
+	path := archivePath(dir, stackName, backup.stack.ArchivedAt)
+	if err := backup.writeDir(path); err != nil {
+		return "", err
+	}
+	if reason := backup.templateTooLarge(); reason != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s; 'cfn restore' cannot recreate stack %q from its archive\n", reason, stackName)
+	}
+	return path, nil
+}
+
+// captureStack fetches everything needed to archive, and later recreate, a
+// stack.
+func captureStack(ctx context.Context, client *cloudformation.Client, stackName, reason string) (stackBackup, error) {
+	stack, err := describeStack(ctx, client, stackName)
+	if err != nil {
+		return stackBackup{}, err
+	}
+	template, err := client.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &stackName,
+		TemplateStage: types.TemplateStageOriginal,
+	})
+	if err != nil {
+		return stackBackup{}, fmt.Errorf("failed to get template: %w", err)
+	}
+	policy, err := client.GetStackPolicy(ctx, &cloudformation.GetStackPolicyInput{StackName: &stackName})
+	if err != nil {
+		return stackBackup{}, fmt.Errorf("failed to get stack policy: %w", err)
+	}
+	resources, err := listStackResources(ctx, client, stackName)
+	if err != nil {
+		return stackBackup{}, fmt.Errorf("failed to list resources: %w", err)
+	}
+
+	body := getValue(template.TemplateBody)
+	templateFile := "template.yaml"
+	if isJSONTemplate(body) {
+		templateFile = "template.json"
+	}
+	parsed, _ := parseTemplateBody(body)
+	parameters, noEcho := splitNoEchoParameters(stack.Parameters, parsed)
+
+	return stackBackup{
+		stack: stackArchive{
+			Version:               archiveVersion,
+			StackName:             getValue(stack.StackName),
+			StackID:               getValue(stack.StackId),
+			Region:                client.Options().Region,
+			Description:           getValue(stack.Description),
+			Status:                stack.StackStatus,
+			StatusReason:          getValue(stack.StackStatusReason),
+			Reason:                reason,
+			ArchivedAt:            time.Now().UTC(),
+			TemplateFile:          templateFile,
+			Parameters:            parameters,
+			NoEchoParameters:      noEcho,
+			Outputs:               stack.Outputs,
+			Tags:                  stack.Tags,
+			Capabilities:          stack.Capabilities,
+			TerminationProtection: aws.ToBool(stack.EnableTerminationProtection),
+			StackPolicy:           getValue(policy.StackPolicyBody),
+			RoleARN:               getValue(stack.RoleARN),
+			NotificationARNs:      stack.NotificationARNs,
+		},
+		template:  body,
+		resources: resources,
+	}, nil
+}
+
+// splitNoEchoParameters separates the NoEcho parameters of a stack, whose
+// values are masked, from the others.
+func splitNoEchoParameters(params []types.Parameter, template map[string]interface{}) ([]types.Parameter, []string) {
+	declared, _ := template["Parameters"].(map[string]interface{})
+	var kept []types.Parameter
+	var noEcho []string
+	for _, p := range params {
+		key := getValue(p.ParameterKey)
+		def, _ := declared[key].(map[string]interface{})
+		if fmt.Sprint(def["NoEcho"]) == "true" || getValue(p.ParameterValue) == noEchoValue {
+			noEcho = append(noEcho, key)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	sort.Strings(noEcho)
+	return kept, noEcho
+}
+
+// files returns the files of the archive by name.
+func (b stackBackup) files() (map[string][]byte, error) {
+	stack, err := json.MarshalIndent(b.stack, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	resources, err := json.MarshalIndent(b.resources, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"stack.json":         append(stack, '\n'),
+		"resources.json":     append(resources, '\n'),
+		b.stack.TemplateFile: []byte(b.template),
+	}, nil
+}
+
+// writeDir writes the archive as a directory.
+func (b stackBackup) writeDir(path string) error {
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(path, name), data, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTar writes the archive as a gzipped tarball.
+func (b stackBackup) writeTar(path string) error {
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(files[name])), ModTime: b.stack.ArchivedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readBackup reads an archive written by writeDir or writeTar.
+func readBackup(path string) (stackBackup, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stackBackup{}, err
+	}
+
+	files := make(map[string][]byte)
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return stackBackup{}, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(path, e.Name()))
+			if err != nil {
+				return stackBackup{}, err
+			}
+			files[e.Name()] = data
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return stackBackup{}, err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return stackBackup{}, fmt.Errorf("not an archive directory or tarball: %w", err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return stackBackup{}, err
+			}
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, tr); err != nil {
+				return stackBackup{}, err
+			}
+			files[strings.TrimPrefix(header.Name, "./")] = buf.Bytes()
+		}
+	}
+	return parseBackupFiles(files)
+}
+
+func parseBackupFiles(files map[string][]byte) (stackBackup, error) {
+	var b stackBackup
+	data, ok := files["stack.json"]
+	if !ok {
+		return b, fmt.Errorf("stack.json not found")
+	}
+	if err := json.Unmarshal(data, &b.stack); err != nil {
+		return b, fmt.Errorf("failed to parse stack.json: %w", err)
+	}
+	if b.stack.Version > archiveVersion {
+		return b, fmt.Errorf("archive version %d is newer than supported (%d)", b.stack.Version, archiveVersion)
+	}
+	template, ok := files[b.stack.TemplateFile]
+	if !ok {
+		return b, fmt.Errorf("template %q not found", b.stack.TemplateFile)
+	}
+	b.template = string(template)
+	if data, ok := files["resources.json"]; ok {
+		if err := json.Unmarshal(data, &b.resources); err != nil {
+			return b, fmt.Errorf("failed to parse resources.json: %w", err)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestArchivePath(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	got := archivePath("/tmp/archive", "my-stack", now)
+	want := filepath.Join("/tmp/archive", "my-stack", "20260304T040607Z")
+	if got != want {
+		t.Errorf("archivePath = %s, want %s", got, want)
+	}
+}
+
+func TestConfirmStackName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"my-stack\n", true},
+		{"  my-stack  \n", true},
+		{"yes\n", false},
+		{"My-Stack\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := confirmStackName("", "my-stack", strings.NewReader(tt.input)); got != tt.want {
+			t.Errorf("confirmStackName(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	backup := stackBackup{
+		stack: stackArchive{
+			Version:          archiveVersion,
+			StackName:        "my-stack",
+			Region:           "eu-west-1",
+			ArchivedAt:       time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+			TemplateFile:     "template.json",
+			Parameters:       []types.Parameter{{ParameterKey: aws.String("Env"), ParameterValue: aws.String("prod")}},
+			NoEchoParameters: []string{"Password"},
+			Capabilities:     []types.Capability{types.CapabilityCapabilityIam},
+			StackPolicy:      `{"Statement":[]}`,
+		},
+		template:  `{"Resources":{}}`,
+		resources: []types.StackResourceSummary{{LogicalResourceId: aws.String("Bucket"), ResourceStatus: types.ResourceStatusCreateComplete}},
+	}
+
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Join(dir, "dir"), filepath.Join(dir, "backup.tar.gz")} {
+		var err error
+		if strings.HasSuffix(path, ".tar.gz") {
+			err = backup.writeTar(path)
+		} else {
+			err = backup.writeDir(path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPrivate(t, path)
+
+		got, err := readBackup(path)
+		if err != nil {
+			t.Fatalf("readBackup(%s): %v", path, err)
+		}
+		if !reflect.DeepEqual(got, backup) {
+			t.Errorf("readBackup(%s) = %+v, want %+v", path, got, backup)
+		}
+	}
+}
+
+// checkPrivate fails unless path and the files below it are only accessible
+// by their owner.
+func checkPrivate(t *testing.T, path string) {
+	t.Helper()
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			t.Errorf("%s has mode %s, want no group or other access", p, info.Mode().Perm())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitNoEchoParameters(t *testing.T) {
+	template, err := parseTemplateBody(`
+Parameters:
+  Env:
+    Type: String
+  Password:
+    Type: String
+    NoEcho: true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := []types.Parameter{
+		{ParameterKey: aws.String("Env"), ParameterValue: aws.String("prod")},
+		{ParameterKey: aws.String("Password"), ParameterValue: aws.String("secret")},
+		{ParameterKey: aws.String("Token"), ParameterValue: aws.String(noEchoValue)},
+	}
+
+	kept, noEcho := splitNoEchoParameters(params, template)
+	if len(kept) != 1 || getValue(kept[0].ParameterKey) != "Env" {
+		t.Errorf("kept = %v, want Env only", kept)
+	}
+	if strings.Join(noEcho, ",") != "Password,Token" {
+		t.Errorf("noEcho = %v, want Password,Token", noEcho)
+	}
+}
@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/spf13/cobra"
+)
+
+// maxTemplateBody is the largest template CreateStack accepts inline.
+const maxTemplateBody = 51200
+
+// templateTooLarge returns why the template of a backup cannot be restored
+// inline, or "" if it fits.
+func (b stackBackup) templateTooLarge() string {
+	if len(b.template) <= maxTemplateBody {
+		return ""
+	}
+	return fmt.Sprintf("template is %d bytes, more than the %d CreateStack accepts inline", len(b.template), maxTemplateBody)
+}
+
+func BackupCmd() *cobra.Command {
+	var dir string
+	var tarball bool
+
+	cmd := &cobra.Command{
+		Use:   "backup <stack-name>",
+		Short: "Save a stack's full definition to a local archive",
+		Long: `Save a stack's full definition to a local archive.
+
+The archive holds the original template, the parameters (except NoEcho ones,
+whose values CloudFormation does not return), tags, capabilities,
+termination protection, stack policy, notification ARNs, role ARN, outputs
+and resource list. It is written to <dir>/<stack>/<timestamp>, as a
+directory or, with --tar, as a .tar.gz file, and can be recreated with
+'cfn restore'. 'cfn delete' writes the same archive before deleting.
+
+Templates of any size are archived, but 'cfn restore' only recreates stacks
+whose template is at most 51,200 bytes, the limit of an inline template
+body; a warning is printed for larger ones.
+
+Examples:
+  # Back up a stack to ~/.cfn/archive/my-stack/<timestamp>
+  cfn backup my-stack
+
+  # Write a tarball to a different directory
+  cfn backup my-stack --dir ./backups --tar`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if dir == "" {
+				dir = defaultArchiveDir()
+			}
+			runBackup(args[0], dir, tarball)
+		},
+	}
+
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "Directory to write the archive to (default ~/.cfn/archive)")
+	cmd.Flags().BoolVar(&tarball, "tar", false, "Write a .tar.gz file instead of a directory")
+
+	return cmd
+}
+
+func runBackup(stackName, dir string, tarball bool) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+
+	backup, err := captureStack(ctx, client, stackName, "backup")
+	if err != nil {
+		fatalf("failed to back up stack %q: %v\n", stackName, err)
+	}
+
+	path := archivePath(dir, stackName, backup.stack.ArchivedAt)
+	if tarball {
+		path += ".tar.gz"
+		err = backup.writeTar(path)
+	} else {
+		err = backup.writeDir(path)
+	}
+	if err != nil {
+		fatalf("failed to write archive: %v\n", err)
+	}
+
+	fmt.Printf("Backed up stack %q to %s\n", stackName, path)
+	if reason := backup.templateTooLarge(); reason != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s; 'cfn restore' cannot recreate this stack\n", reason)
+	}
+	if len(backup.stack.NoEchoParameters) > 0 {
+		fmt.Printf("NoEcho parameters not saved (pass them to 'cfn restore' with --parameter): %s\n", strings.Join(backup.stack.NoEchoParameters, ", "))
+	}
+}
+
+func RestoreCmd() *cobra.Command {
+	var stackName string
+	var parameters []string
+	var yes bool
+	var wait bool
+
+	cmd := &cobra.Command{
+		Use:   "restore <archive>",
+		Short: "Recreate a stack from an archive",
+		Long: `Recreate a stack from an archive written by 'cfn backup' or 'cfn delete'.
+
+The stack is created with the archived template, parameters, tags,
+capabilities, termination protection, stack policy, notification ARNs and
+role ARN. Use --stack-name to restore under a new name and --region to
+restore into another region; notification ARNs from another region are
+dropped. NoEcho parameters are not archived and must be passed with
+--parameter, which can also override archived values.
+
+The template is passed inline, so archives whose template is larger than
+51,200 bytes cannot be restored.
+
+Examples:
+  # Recreate a deleted stack
+  cfn restore ~/.cfn/archive/my-stack/20260101T120000Z
+
+  # Restore a copy under a new name in another region
+  cfn restore ./backups/my-stack/20260101T120000Z.tar.gz --stack-name my-stack-dr --region eu-west-1
+
+  # Provide a NoEcho parameter
+  cfn restore ./backups/my-stack/20260101T120000Z --parameter DbPassword=secret`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runRestore(args[0], stackName, parameters, yes, wait)
+		},
+	}
+
+	cmd.Flags().StringVar(&stackName, "stack-name", "", "Name of the stack to create (default the archived name)")
+	cmd.Flags().StringArrayVarP(&parameters, "parameter", "p", []string{}, "Parameter value as KEY=VALUE (repeatable)")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip interactive confirmation")
+	cmd.Flags().BoolVarP(&wait, "wait", "w", true, "Follow the stack events until creation completes")
+
+	return cmd
+}
+
+func runRestore(path, stackName string, overrides []string, yes, wait bool) {
+	backup, err := readBackup(path)
+	if err != nil {
+		fatalf("failed to read archive %q: %v\n", path, err)
+	}
+	archived := backup.stack
+	if stackName == "" {
+		stackName = archived.StackName
+	}
+	if reason := backup.templateTooLarge(); reason != "" {
+		fatalf("%s\n", reason)
+	}
+	params, err := restoreParameters(archived, overrides)
+	if err != nil {
+		fatalf("%v\n", err)
+	}
+
+	ctx := context.Background()
+	client := mustClient(ctx)
+	targetRegion := client.Options().Region
+
+	notificationARNs := sameRegionARNs(archived.NotificationARNs, targetRegion)
+	for _, arn := range archived.NotificationARNs {
+		if !slices.Contains(notificationARNs, arn) {
+			fmt.Fprintf(os.Stderr, "warning: dropping notification ARN %s from another region\n", arn)
+		}
+	}
+
+	fmt.Printf("Restoring stack %q from %q (%s, archived %s)\n", stackName, archived.StackName, archived.Region, archived.ArchivedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Region:                 %s\n", targetRegion)
+	fmt.Printf("  Parameters:             %d\n", len(params))
+	fmt.Printf("  Tags:                   %d\n", len(archived.Tags))
+	if len(archived.Capabilities) > 0 {
+		caps := make([]string, len(archived.Capabilities))
+		for i, c := range archived.Capabilities {
+			caps[i] = string(c)
+		}
+		fmt.Printf("  Capabilities:           %s\n", strings.Join(caps, ", "))
+	}
+	fmt.Printf("  Termination protection: %v\n", archived.TerminationProtection)
+	fmt.Printf("  Stack policy:           %v\n", archived.StackPolicy != "")
+	if archived.RoleARN != "" {
+		fmt.Printf("  IAM role:               %s\n", archived.RoleARN)
+	}
+	fmt.Println()
+
+	if !yes && !confirm(fmt.Sprintf("Create stack %q? Type 'yes' to confirm: ", stackName), os.Stdin) {
+		fatalf("aborted\n")
+	}
+
+	if err := createFromBackup(ctx, client, backup, stackName, params, notificationARNs); err != nil {
+		fatalf("failed to create stack %q: %v\n", stackName, err)
+	}
+	fmt.Printf("Creation started for stack %q\n", stackName)
+
+	if wait {
+		tailUntilComplete(stackName)
+	}
+}
+
+// createFromBackup creates a stack with the archived definition.
+func createFromBackup(ctx context.Context, client *cloudformation.Client, backup stackBackup, stackName string, params []types.Parameter, notificationARNs []string) error {
+	archived := backup.stack
+	input := &cloudformation.CreateStackInput{
+		StackName:                   &stackName,
+		TemplateBody:                &backup.template,
+		Parameters:                  params,
+		Tags:                        archived.Tags,
+		Capabilities:                archived.Capabilities,
+		EnableTerminationProtection: aws.Bool(archived.TerminationProtection),
+		NotificationARNs:            notificationARNs,
+	}
+	if archived.StackPolicy != "" {
+		input.StackPolicyBody = &archived.StackPolicy
+	}
+	if archived.RoleARN != "" {
+		input.RoleARN = &archived.RoleARN
+	}
+	_, err := client.CreateStack(ctx, input)
+	return err
+}
+
+// tailUntilComplete follows the events of a stack, with the defaults of
+// 'cfn tail --until-complete', and exits non-zero if the operation fails.
+func tailUntilComplete(stackName string) {
+	runTail(stackName, tailOptions{
+		interval:      5 * time.Second,
+		untilComplete: true,
+		rate:          5,
+		progress:      true,
+		stuckAfter:    10 * time.Minute,
+		output:        "table",
+	})
+}
+
+// restoreParameters returns the archived parameters with the KEY=VALUE
+// overrides applied. Every NoEcho parameter must be overridden.
+func restoreParameters(archived stackArchive, overrides []string) ([]types.Parameter, error) {
+	values := make(map[string]string)
+	for _, p := range archived.Parameters {
+		values[getValue(p.ParameterKey)] = getValue(p.ParameterValue)
+	}
+	given := make(map[string]bool)
+	for _, o := range overrides {
+		key, value, ok := strings.Cut(o, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --parameter %q (expected KEY=VALUE)", o)
+		}
+		values[key] = value
+		given[key] = true
+	}
+
+	var missing []string
+	for _, key := range archived.NoEchoParameters {
+		if !given[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("NoEcho parameters are not archived; pass them with --parameter: %s", strings.Join(missing, ", "))
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]types.Parameter, len(keys))
+	for i, key := range keys {
+		params[i] = types.Parameter{ParameterKey: aws.String(key), ParameterValue: aws.String(values[key])}
+	}
+	return params, nil
+}
+
+// sameRegionARNs returns the ARNs that belong to region.
+func sameRegionARNs(arns []string, region string) []string {
+	var kept []string
+	for _, arn := range arns {
+		parts := strings.Split(arn, ":")
+		if len(parts) > 3 && parts[3] == region {
+			kept = append(kept, arn)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestRestoreParameters(t *testing.T) {
+	archived := stackArchive{
+		Parameters: []types.Parameter{
+			{ParameterKey: aws.String("Env"), ParameterValue: aws.String("prod")},
+			{ParameterKey: aws.String("Size"), ParameterValue: aws.String("small")},
+		},
+		NoEchoParameters: []string{"Password"},
+	}
+
+	tests := []struct {
+		name      string
+		overrides []string
+		want      string
+		err       string
+	}{
+		{"missing NoEcho", nil, "", "pass them with --parameter: Password"},
+		{"invalid override", []string{"Password"}, "", "invalid --parameter"},
+		{"override", []string{"Password=secret", "Size=large"}, "Env=prod,Password=secret,Size=large", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := restoreParameters(archived, tt.overrides)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range params {
+				got = append(got, getValue(p.ParameterKey)+"="+getValue(p.ParameterValue))
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("params = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+}
+
+func TestSameRegionARNs(t *testing.T) {
+	arns := []string{
+		"arn:aws:sns:eu-west-1:123456789012:alerts",
+		"arn:aws:sns:us-east-1:123456789012:alerts",
+		"invalid",
+	}
+	got := sameRegionARNs(arns, "eu-west-1")
+	if len(got) != 1 || got[0] != arns[0] {
+		t.Errorf("sameRegionARNs = %v, want %v", got, arns[:1])
+	}
+}
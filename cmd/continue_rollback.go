@@ -28,10 +28,13 @@ func ContinueRollbackCmd() *cobra.Command {
 		Long: `Continue rolling back a stack that is stuck in UPDATE_ROLLBACK_FAILED state.
 
 Lists resources in UPDATE_FAILED state (eligible for skipping) before proceeding.
-Use --skip to skip specific resources that cannot be rolled back.
+Use --skip to skip specific resources that cannot be rolled back. Without
+--skip or --yes, on a terminal, the failed resources are listed with their
+physical IDs and reasons and can be picked interactively; picking stateful
+resources such as databases or buckets asks for confirmation.
 
 Examples:
-  # Continue rollback (shows failed resources and prompts)
+  # Continue rollback (pick the failed resources to skip interactively)
   cfn continue-rollback my-stack
 
   # Skip a problematic resource
@@ -80,7 +83,18 @@ func runContinueRollback(stackName string, skip []string, roleARN string, yes bo
 		}
 	}
 
-	if len(failedResources) > 0 {
+	if len(failedResources) > 0 && len(skip) == 0 && !yes && stdinIsTTY() {
+		candidates := make([]skipCandidate, len(failedResources))
+		for i, r := range failedResources {
+			candidates[i] = newSkipCandidate("", r)
+		}
+		picked, err := pickSkips(candidates, false, os.Stdin, os.Stderr)
+		if err != nil {
+			fatalf("%v\n", err)
+		}
+		skip = picked
+		fmt.Fprintln(os.Stderr)
+	} else if len(failedResources) > 0 {
 		fmt.Fprintf(os.Stderr, "Resources in UPDATE_FAILED state (eligible for --skip):\n")
 		for _, r := range failedResources {
 			reason := getValue(r.ResourceStatusReason)
@@ -10,8 +10,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/cloudcontrol"
-	cctypes "github.com/aws/aws-sdk-go-v2/service/cloudcontrol/types"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/aws/smithy-go"
@@ -19,20 +17,59 @@ import (
 )
 
 func DeleteCmd() *cobra.Command {
-	var yes bool
-	var wait bool
-	var retainResources []string
-	var cloudcontrolDelete bool
-	var dryRun bool
+	var opts deleteOptions
 
 	cmd := &cobra.Command{
-		Use:     "delete <stack-name>",
+		Use:     "delete [stack-name...]",
 		Aliases: []string{"rm", "del"},
 		Short:   "Delete a CloudFormation stack",
 		Long: `Delete a CloudFormation stack.
 
 By default this command asks for confirmation and waits until deletion completes.
 
+Before asking, it shows a pre-delete plan: termination protection, exports
+and the stacks still importing them, the resources kept by a Retain or
+Snapshot DeletionPolicy, and stateful resources whose data would be lost
+(non-empty S3 buckets, DynamoDB tables and ECR repositories, databases, file
+systems...). Deletion is refused while termination protection is enabled,
+while an export is still imported, and for nested stacks, which must be
+deleted through their parent. --skip-checks bypasses the plan.
+
+--cloudcontrol-delete deletes the stack resources through the Cloud Control
+API first, in waves ordered by the Ref, Fn::GetAtt, Fn::Sub and DependsOn
+references of the deployed template: a resource is deleted after every
+resource referencing it. --concurrency limits the deletions running at once.
+Resources that fail are retried once their dependents are gone.
+
+Before deleting, the template, parameters, outputs, tags and resource list of
+each stack are saved to an archive directory (~/.cfn/archive/<stack>/<time>
+by default), so what was deleted and what was left behind can always be
+looked up later. --no-archive disables it.
+
+--force deletes a stack stuck in DELETE_FAILED with the FORCE_DELETE_STACK
+deletion mode: the resources that failed to delete are left behind and
+listed at the end. It always asks to type the stack name to confirm, even
+with --yes.
+
+With --auto-retain, a deletion that ends in DELETE_FAILED is retried: the
+resources that failed to delete are listed with their reasons and, after
+confirmation, the deletion is restarted retaining them. The retained
+resources are reported at the end, since they are left behind outside of
+CloudFormation.
+
+Several stacks can be deleted at once by name, by glob pattern with --match,
+with the stack filters, or by passing "-" to read names from stdin (as
+printed by 'cfn list -1'). The exports and imports between them are used to
+delete them in waves: a stack is deleted only after every stack importing
+its exports. Nested stacks are deleted with their parent. The whole plan is
+confirmed once, each wave is deleted in parallel and a result table is
+printed at the end. Stacks still importing from a stack that failed to be
+deleted are skipped.
+
+The --notify-* flags send a notification when a deletion finishes. While
+waiting, the first resource that fails to delete and the resources deleting
+for longer than --stuck-after are notified too.
+
 Examples:
   # Delete a stack (with confirmation)
   cfn delete my-stack
@@ -49,54 +86,215 @@ Examples:
   # Delete resources via Cloud Control API before deleting the stack
   cfn delete my-stack --cloudcontrol-delete
 
-  # Preview what --cloudcontrol-delete would do without making changes
-  cfn delete my-stack --cloudcontrol-delete --dry-run`,
-		Args: cobra.ExactArgs(1),
+  # Retry a failed deletion, leaving the resources that cannot be deleted behind
+  cfn delete my-stack --auto-retain
+
+  # Force-delete a stack stuck in DELETE_FAILED
+  cfn delete my-stack --force
+
+  # Only show the pre-delete plan
+  cfn delete my-stack --dry-run
+
+  # Preview the Cloud Control deletion waves without making changes
+  cfn delete my-stack --cloudcontrol-delete --dry-run
+
+  # Delete up to 10 resources at a time via Cloud Control
+  cfn delete my-stack --cloudcontrol-delete --concurrency 10
+
+  # Post to a Slack webhook when the deletion finishes
+  cfn delete my-stack --yes --notify-webhook https://hooks.slack.com/services/...
+
+  # Delete every stack of a pull request environment
+  cfn delete --match 'pr-1234-*'
+
+  # Delete the stacks listed by another command
+  cfn list -1 pr-1234 | cfn delete - --yes`,
+		Args: cobra.ArbitraryArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			runDelete(args[0], yes, wait, retainResources, cloudcontrolDelete, dryRun)
+			if err := opts.notify.validate(); err != nil {
+				fatalf("%v\n", err)
+			}
+			if len(args) == 0 && opts.match == "" && !opts.filters.isSet() {
+				fatalf("requires a stack name, --match or a stack filter\n")
+			}
+			if opts.concurrency < 1 {
+				fatalf("--concurrency must be at least 1\n")
+			}
+			if opts.archiveDir == "" {
+				opts.archiveDir = defaultArchiveDir()
+			}
+			fromStdin := len(args) == 1 && args[0] == "-"
+			if opts.match != "" || opts.filters.isSet() || len(args) != 1 || fromStdin {
+				if opts.single() {
+					fatalf("--retain-resource, --cloudcontrol-delete, --skip-checks, --auto-retain, --force and --wait=false only apply to a single stack\n")
+				}
+				names := args
+				if fromStdin {
+					var err error
+					if names, err = readStackNames(os.Stdin); err != nil {
+						fatalf("failed to read stack names: %v\n", err)
+					}
+				}
+				runBulkDelete(names, fromStdin, opts)
+				return
+			}
+			if opts.autoRetain && !opts.wait {
+				fatalf("--auto-retain requires --wait\n")
+			}
+			if opts.force && (opts.autoRetain || len(opts.retainResources) > 0) {
+				fatalf("--force cannot be combined with --auto-retain or --retain-resource\n")
+			}
+			runDelete(args[0], opts)
 		},
 	}
 
-	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip interactive confirmation")
-	cmd.Flags().BoolVarP(&wait, "wait", "w", true, "Wait for stack deletion to complete")
-	cmd.Flags().StringArrayVar(&retainResources, "retain-resource", []string{}, "Logical resource ID to retain during deletion (repeatable)")
-	cmd.Flags().BoolVar(&cloudcontrolDelete, "cloudcontrol-delete", false, "Delete resources via Cloud Control API before deleting the stack")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what --cloudcontrol-delete would do without making changes")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Skip interactive confirmation")
+	cmd.Flags().BoolVarP(&opts.wait, "wait", "w", true, "Wait for stack deletion to complete")
+	cmd.Flags().StringArrayVar(&opts.retainResources, "retain-resource", []string{}, "Logical resource ID to retain during deletion (repeatable)")
+	cmd.Flags().BoolVar(&opts.cloudcontrolDelete, "cloudcontrol-delete", false, "Delete resources via Cloud Control API before deleting the stack")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Show the pre-delete plan and what --cloudcontrol-delete would do without making changes")
+	cmd.Flags().BoolVar(&opts.skipChecks, "skip-checks", false, "Skip the pre-delete safety checks")
+	cmd.Flags().BoolVar(&opts.autoRetain, "auto-retain", false, "On DELETE_FAILED, retry retaining the resources that failed to delete")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Force-delete a DELETE_FAILED stack, leaving the resources that failed to delete behind")
+	cmd.Flags().StringVar(&opts.archiveDir, "archive-dir", "", "Directory to archive stacks to before deletion (default ~/.cfn/archive)")
+	cmd.Flags().BoolVar(&opts.noArchive, "no-archive", false, "Do not archive stacks before deletion")
+	cmd.Flags().StringVarP(&opts.match, "match", "m", "", "Delete every stack whose name matches a glob pattern")
+	cmd.Flags().IntVar(&opts.concurrency, "concurrency", 5, "Maximum number of stacks, or Cloud Control resources, checked or deleted at the same time")
+	cmd.Flags().DurationVar(&opts.stuckAfter, "stuck-after", defaultStuckAfter, "Notify about resources deleting for longer than this (0 disables)")
+	opts.filters.register(cmd)
+	opts.notify.register(cmd)
 
 	return cmd
 }
 
-func runDelete(stackName string, yes bool, wait bool, retainResources []string, cloudcontrolDelete bool, dryRun bool) {
-	if dryRun && !cloudcontrolDelete {
-		fatalf("--dry-run requires --cloudcontrol-delete\n")
+// deleteOptions holds the flags of the delete command.
+type deleteOptions struct {
+	yes                bool
+	wait               bool
+	retainResources    []string
+	cloudcontrolDelete bool
+	dryRun             bool
+	skipChecks         bool
+	autoRetain         bool
+	force              bool
+	archiveDir         string
+	noArchive          bool
+	match              string
+	filters            stackFilters
+	concurrency        int
+	stuckAfter         time.Duration
+	notify             notifyOptions
+}
+
+// single reports whether a flag that only applies to a single stack is set.
+func (o deleteOptions) single() bool {
+	return len(o.retainResources) > 0 || o.cloudcontrolDelete || o.skipChecks || o.autoRetain || o.force || !o.wait
+}
+
+// archive saves the stack to the archive directory, unless disabled.
+func (o deleteOptions) archive(ctx context.Context, client *cloudformation.Client, stackName string) {
+	if o.noArchive {
+		return
 	}
+	reason := "delete"
+	if o.force {
+		reason = "force-delete"
+	}
+	path, err := archiveStack(ctx, client, stackName, o.archiveDir, reason)
+	if err != nil {
+		fatalf("failed to archive stack %q (use --no-archive to skip): %v\n", stackName, err)
+	}
+	fmt.Printf("Archived stack %q to %s\n", stackName, path)
+}
 
-	if !dryRun && !yes {
-		if cloudcontrolDelete {
+func runDelete(stackName string, opts deleteOptions) {
+	yes, dryRun, cloudcontrolDelete := opts.yes, opts.dryRun, opts.cloudcontrolDelete
+	retainResources := opts.retainResources
+	if dryRun && opts.skipChecks && !cloudcontrolDelete {
+		fatalf("--dry-run with --skip-checks has nothing to show without --cloudcontrol-delete\n")
+	}
+
+	ctx := context.Background()
+	cfnClient := mustClient(ctx)
+
+	if opts.force {
+		stack, err := describeStack(ctx, cfnClient, stackName)
+		if err != nil {
+			fatalf("failed to describe stack %q: %v\n", stackName, err)
+		}
+		if stack.StackStatus != types.StackStatusDeleteFailed {
+			fatalf("--force only applies to stacks in DELETE_FAILED; stack %q is %s\n", stackName, stack.StackStatus)
+		}
+	}
+
+	if !opts.skipChecks {
+		plan, err := buildDeletePlan(ctx, cfnClient, stackName, retainResources)
+		if err != nil {
+			fatalf("failed to check stack %q: %v\n", stackName, err)
+		}
+		plan.print(os.Stdout)
+		if blockers := plan.blockers(); len(blockers) > 0 {
+			fatalf("refusing to delete stack %q: %d blocking issue(s)\n", stackName, len(blockers))
+		}
+	}
+
+	if !dryRun {
+		switch {
+		case opts.force:
+			// A force deletion leaves resources behind: --yes does not skip this
+			prompt := fmt.Sprintf("Force-deleting stack %q leaves every resource that fails to delete behind.\nType the stack name to confirm: ", stackName)
+			if !confirmStackName(prompt, stackName, os.Stdin) {
+				fatalf("aborted\n")
+			}
+		case yes:
+		case cloudcontrolDelete:
 			if !confirmDelete(fmt.Sprintf("%s (resources will be deleted via Cloud Control first)", stackName)) {
 				fatalf("aborted\n")
 			}
-		} else {
+		default:
 			if !confirmDelete(stackName) {
 				fatalf("aborted\n")
 			}
 		}
 	}
 
-	ctx := context.Background()
-	cfnClient := mustClient(ctx)
+	if dryRun {
+		if cloudcontrolDelete {
+			preDeleteResources(ctx, cfnClient, stackName, true, opts.concurrency)
+		}
+		return
+	}
 
-	if cloudcontrolDelete {
-		preDeleteResources(ctx, cfnClient, stackName, dryRun)
-		if dryRun {
-			return
+	// Archive the stack while all its resources are still there
+	opts.archive(ctx, cfnClient, stackName)
+
+	// The resources that failed to delete are left behind by a force deletion
+	var orphans []types.StackResourceSummary
+	if opts.force {
+		resources, err := listStackResources(ctx, cfnClient, stackName)
+		if err != nil {
+			fatalf("failed to list resources for stack %q: %v\n", stackName, err)
 		}
+		orphans = deleteFailedResources(resources, nil)
+	}
+
+	if cloudcontrolDelete {
+		preDeleteResources(ctx, cfnClient, stackName, false, opts.concurrency)
 	}
 
 	input := &cloudformation.DeleteStackInput{StackName: &stackName}
 	if len(retainResources) > 0 {
 		input.RetainResources = retainResources
 	}
+	if opts.force {
+		input.DeletionMode = types.DeletionModeForceDeleteStack
+	}
+
+	notifier := newNotifier(opts.notify)
+	var watcher *deleteWatcher
+	if opts.wait {
+		watcher = newDeleteWatcher(ctx, cfnClient, notifier, stackName, opts.stuckAfter)
+	}
 
 	if _, err := cfnClient.DeleteStack(ctx, input); err != nil {
 		fatalf("failed to delete stack %q: %v\n", stackName, err)
@@ -104,7 +302,7 @@ func runDelete(stackName string, yes bool, wait bool, retainResources []string,
 
 	fmt.Printf("Deletion started for stack %q\n", stackName)
 
-	if !wait {
+	if !opts.wait {
 		fmt.Println("Use --wait to poll for completion automatically.")
 		return
 	}
@@ -113,11 +311,14 @@ func runDelete(stackName string, yes bool, wait bool, retainResources []string,
 	for {
 		time.Sleep(3 * time.Second)
 		fmt.Print(".")
+		watcher.poll(ctx)
 
 		out, err := cfnClient.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
 		if err != nil {
 			if isStackNotFound(err) {
 				fmt.Printf("\nStack %q deleted\n", stackName)
+				printOrphans(orphans)
+				notifier.stackStatus(ctx, stackName, types.StackStatusDeleteComplete, "")
 				return
 			}
 			fatalf("\nfailed to check deletion status for %q: %v\n", stackName, err)
@@ -125,6 +326,8 @@ func runDelete(stackName string, yes bool, wait bool, retainResources []string,
 
 		if len(out.Stacks) == 0 {
 			fmt.Printf("\nStack %q deleted\n", stackName)
+			printOrphans(orphans)
+			notifier.stackStatus(ctx, stackName, types.StackStatusDeleteComplete, "")
 			return
 		}
 
@@ -132,34 +335,38 @@ func runDelete(stackName string, yes bool, wait bool, retainResources []string,
 		switch stack.StackStatus {
 		case types.StackStatusDeleteComplete:
 			fmt.Printf("\nStack %q deleted\n", stackName)
+			printOrphans(orphans)
+			notifier.stackStatus(ctx, stackName, types.StackStatusDeleteComplete, "")
 			return
 		case types.StackStatusDeleteFailed:
+			// Catch the resource failures logged since the last poll
+			watcher.poll(ctx)
+			if opts.autoRetain {
+				fmt.Printf("\nDeletion of stack %q failed: %s\n\n", stackName, getValue(stack.StackStatusReason))
+				if retained := retryDeleteRetaining(ctx, cfnClient, stackName, retainResources, yes); len(retained) > 0 {
+					orphans = append(orphans, retained...)
+					for _, r := range retained {
+						retainResources = append(retainResources, getValue(r.LogicalResourceId))
+					}
+					fmt.Print("Waiting")
+					continue
+				}
+				printOrphans(orphans)
+			}
+			notifier.stackStatus(ctx, stackName, stack.StackStatus, getValue(stack.StackStatusReason))
 			fatalf("\ndelete failed for stack %q: %s\n", stackName, getValue(stack.StackStatusReason))
 		}
 	}
 }
 
-func preDeleteResources(ctx context.Context, cfnClient *cloudformation.Client, stackName string, dryRun bool) {
-	// List all resources in the stack
-	var resources []types.StackResourceSummary
-	paginator := cloudformation.NewListStackResourcesPaginator(cfnClient, &cloudformation.ListStackResourcesInput{
-		StackName: &stackName,
-	})
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(ctx)
-		if err != nil {
-			fatalf("failed to list resources for stack %q: %v\n", stackName, err)
-		}
-		resources = append(resources, output.StackResourceSummaries...)
+func preDeleteResources(ctx context.Context, cfnClient *cloudformation.Client, stackName string, dryRun bool, concurrency int) {
+	resources, err := listStackResources(ctx, cfnClient, stackName)
+	if err != nil {
+		fatalf("failed to list resources for stack %q: %v\n", stackName, err)
 	}
 
 	// Filter: skip nested stacks and resources without a physical ID
-	type target struct {
-		logicalID  string
-		physicalID string
-		typeName   string
-	}
-	var targets []target
+	var targets []ccTarget
 	for _, r := range resources {
 		resType := getValue(r.ResourceType)
 		if resType == "AWS::CloudFormation::Stack" {
@@ -173,7 +380,7 @@ func preDeleteResources(ctx context.Context, cfnClient *cloudformation.Client, s
 		if !strings.HasPrefix(resType, "AWS::") {
 			continue
 		}
-		targets = append(targets, target{
+		targets = append(targets, ccTarget{
 			logicalID:  getValue(r.LogicalResourceId),
 			physicalID: pid,
 			typeName:   resType,
@@ -185,10 +392,35 @@ func preDeleteResources(ctx context.Context, cfnClient *cloudformation.Client, s
 		return
 	}
 
+	// Delete dependents before the resources they reference
+	var deps map[string][]string
+	out, err := cfnClient.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &stackName,
+		TemplateStage: types.TemplateStageProcessed,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to get template, resources are deleted in any order: %v\n", err)
+	} else if doc, err := parseTemplateNode(getValue(out.TemplateBody)); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to parse template, resources are deleted in any order: %v\n", err)
+	} else {
+		deps = templateDependencies(doc)
+	}
+
 	if dryRun {
-		fmt.Printf("Dry run: would delete %d resource(s) via Cloud Control API before stack deletion:\n", len(targets))
-		for _, t := range targets {
-			fmt.Printf("  cloudcontrol delete-resource --type-name %s --identifier %s  (logical: %s)\n", t.typeName, t.physicalID, t.logicalID)
+		byID := make(map[string]ccTarget)
+		ids := make([]string, len(targets))
+		for i, t := range targets {
+			byID[t.logicalID] = t
+			ids[i] = t.logicalID
+		}
+		waves := deletionWaves(ids, deps)
+		fmt.Printf("Dry run: would delete %d resource(s) via Cloud Control API in %d wave(s) before stack deletion:\n", len(targets), len(waves))
+		for i, wave := range waves {
+			fmt.Printf("  Wave %d:\n", i+1)
+			for _, id := range wave {
+				t := byID[id]
+				fmt.Printf("    cloudcontrol delete-resource --type-name %s --identifier %s  (logical: %s)\n", t.typeName, t.physicalID, t.logicalID)
+			}
 		}
 		fmt.Printf("  Then: cloudformation delete-stack --stack-name %s\n", stackName)
 		return
@@ -197,66 +429,22 @@ func preDeleteResources(ctx context.Context, cfnClient *cloudformation.Client, s
 	ccClient := mustCloudControlClient(ctx)
 
 	fmt.Printf("Deleting %d resource(s) via Cloud Control API...\n", len(targets))
-
-	type inflight struct {
-		target
-		requestToken string
-	}
-	var pending []inflight
-
-	for _, t := range targets {
-		fmt.Printf("  Deleting %s %s (%s)...", t.typeName, t.physicalID, t.logicalID)
-		out, err := ccClient.DeleteResource(ctx, &cloudcontrol.DeleteResourceInput{
-			TypeName:   &t.typeName,
-			Identifier: &t.physicalID,
-		})
-		if err != nil {
-			fmt.Printf(" error: %v\n", err)
-			continue
-		}
-		token := getValue(out.ProgressEvent.RequestToken)
-		if token == "" {
-			fmt.Printf(" started (no token)\n")
-			continue
-		}
-		fmt.Printf(" started (token: %s)\n", token)
-		pending = append(pending, inflight{target: t, requestToken: token})
-	}
-
-	// Poll all inflight deletions until they complete
-	for len(pending) > 0 {
-		time.Sleep(3 * time.Second)
-		var still []inflight
-		for _, p := range pending {
-			status, err := ccClient.GetResourceRequestStatus(ctx, &cloudcontrol.GetResourceRequestStatusInput{
-				RequestToken: &p.requestToken,
-			})
-			if err != nil {
-				fmt.Printf("  %s: failed to poll status: %v\n", p.logicalID, err)
-				continue
-			}
-			opStatus := status.ProgressEvent.OperationStatus
-			switch opStatus {
-			case cctypes.OperationStatusSuccess:
-				fmt.Printf("  %s: deleted\n", p.logicalID)
-			case cctypes.OperationStatusFailed:
-				msg := getValue(status.ProgressEvent.StatusMessage)
-				fmt.Printf("  %s: delete failed: %s\n", p.logicalID, msg)
-			case cctypes.OperationStatusCancelComplete:
-				fmt.Printf("  %s: cancelled\n", p.logicalID)
-			default:
-				still = append(still, p)
-			}
-		}
-		pending = still
+	if failed := deleteInWaves(ctx, ccClient, targets, deps, concurrency); len(failed) > 0 {
+		fmt.Printf("%d resource(s) could not be deleted via Cloud Control; the stack deletion will try again\n", len(failed))
+		return
 	}
 
 	fmt.Println("Cloud Control resource deletion complete")
 }
 
 func confirmDelete(stackName string) bool {
-	fmt.Fprintf(os.Stderr, "Delete stack %q? Type 'yes' to confirm: ", stackName)
-	reader := bufio.NewReader(os.Stdin)
+	return confirm(fmt.Sprintf("Delete stack %q? Type 'yes' to confirm: ", stackName), os.Stdin)
+}
+
+// confirm prints the prompt and reports whether "yes" is read from in.
+func confirm(prompt string, in io.Reader) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	reader := bufio.NewReader(in)
 	input, err := reader.ReadString('\n')
 	if err != nil && !errors.Is(err, io.EOF) {
 		return false
@@ -264,6 +452,17 @@ func confirmDelete(stackName string) bool {
 	return strings.EqualFold(strings.TrimSpace(input), "yes")
 }
 
+// confirmStackName prints the prompt and reports whether the stack name is
+// read from in.
+func confirmStackName(prompt, stackName string, in io.Reader) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	input, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	return strings.TrimSpace(input) == stackName
+}
+
 func isStackNotFound(err error) bool {
 	var apiErr smithy.APIError
 	if !errors.As(err, &apiErr) {
@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// bulkDeletePlan orders the deletion of several stacks into waves: a stack is
+// deleted only after every stack importing its exports is gone.
+type bulkDeletePlan struct {
+	waves     [][]string
+	dependsOn map[string][]string // stack -> importers that must be deleted first
+	nested    map[string]string   // nested stack -> ancestor deleted with it
+	blockers  []string
+}
+
+// planBulkDelete builds the deletion waves from the pre-delete plans of the
+// stacks to delete.
+func planBulkDelete(plans []deletePlan) bulkDeletePlan {
+	bulk := bulkDeletePlan{
+		dependsOn: make(map[string][]string),
+		nested:    make(map[string]string),
+	}
+
+	selected := make(map[string]bool)
+	for _, p := range plans {
+		selected[p.stackName] = true
+	}
+
+	// Nested stacks are deleted along with a selected ancestor.
+	var stacks []deletePlan
+	for _, p := range plans {
+		if p.parentID != "" {
+			if ancestor := selectedAncestor(p, selected); ancestor != "" {
+				bulk.nested[p.stackName] = ancestor
+				continue
+			}
+		}
+		stacks = append(stacks, p)
+	}
+	owner := func(name string) string {
+		if ancestor, ok := bulk.nested[name]; ok {
+			return ancestor
+		}
+		return name
+	}
+
+	remaining := make(map[string]bool)
+	for _, p := range stacks {
+		remaining[p.stackName] = true
+	}
+	for _, p := range stacks {
+		if p.terminationProtected {
+			bulk.blockers = append(bulk.blockers, p.stackName+": termination protection is enabled")
+		}
+		if p.parentID != "" {
+			bulk.blockers = append(bulk.blockers, fmt.Sprintf("%s: nested stack of %s, which is not being deleted", p.stackName, stackNameFromARN(p.parentID)))
+		}
+		exports := make([]string, 0, len(p.imports))
+		for export := range p.imports {
+			exports = append(exports, export)
+		}
+		sort.Strings(exports)
+		for _, export := range exports {
+			for _, importer := range p.imports[export] {
+				imp := owner(importer)
+				switch {
+				case imp == p.stackName:
+					// Imported by one of its own nested stacks.
+				case remaining[imp]:
+					if !slices.Contains(bulk.dependsOn[p.stackName], imp) {
+						bulk.dependsOn[p.stackName] = append(bulk.dependsOn[p.stackName], imp)
+					}
+				default:
+					bulk.blockers = append(bulk.blockers, fmt.Sprintf("%s: export %s is imported by %s, which is not being deleted", p.stackName, export, importer))
+				}
+			}
+		}
+	}
+
+	deleted := make(map[string]bool)
+	for len(remaining) > 0 {
+		var wave []string
+		for name := range remaining {
+			ready := true
+			for _, dep := range bulk.dependsOn[name] {
+				if !deleted[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, name)
+			}
+		}
+		if len(wave) == 0 {
+			var cycle []string
+			for name := range remaining {
+				cycle = append(cycle, name)
+			}
+			sort.Strings(cycle)
+			bulk.blockers = append(bulk.blockers, "circular export/import dependency between "+strings.Join(cycle, ", "))
+			break
+		}
+		sort.Strings(wave)
+		for _, name := range wave {
+			delete(remaining, name)
+			deleted[name] = true
+		}
+		bulk.waves = append(bulk.waves, wave)
+	}
+	return bulk
+}
+
+// archived returns every stack deleted by the plan: those of the waves, then
+// the nested stacks deleted with them.
+func (b bulkDeletePlan) archived() []string {
+	var names []string
+	for _, wave := range b.waves {
+		names = append(names, wave...)
+	}
+	nested := make([]string, 0, len(b.nested))
+	for name := range b.nested {
+		nested = append(nested, name)
+	}
+	sort.Strings(nested)
+	return append(names, nested...)
+}
+
+// selectedAncestor returns the parent or root stack of a nested stack if it is
+// among the selected stacks.
+func selectedAncestor(p deletePlan, selected map[string]bool) string {
+	for _, id := range []string{p.parentID, p.rootID} {
+		if name := stackNameFromARN(id); id != "" && selected[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+func (b bulkDeletePlan) count() int {
+	n := 0
+	for _, wave := range b.waves {
+		n += len(wave)
+	}
+	return n
+}
+
+func (b bulkDeletePlan) print(plans map[string]deletePlan) {
+	table := makeTable([]string{"WAVE", "STACK", "AFTER", "KEPT RESOURCES", "DATA DELETED"})
+	for i, wave := range b.waves {
+		for _, name := range wave {
+			p := plans[name]
+			table.Rows = append(table.Rows, v1.TableRow{
+				Cells: []interface{}{
+					i + 1,
+					name,
+					strings.Join(b.dependsOn[name], ", "),
+					len(p.retained),
+					len(p.stateful),
+				},
+			})
+		}
+	}
+	mustPrint(table)
+
+	nested := make([]string, 0, len(b.nested))
+	for name := range b.nested {
+		nested = append(nested, name)
+	}
+	sort.Strings(nested)
+	for _, name := range nested {
+		fmt.Printf("  %s is deleted with %s\n", name, b.nested[name])
+	}
+
+	for _, wave := range b.waves {
+		for _, name := range wave {
+			p := plans[name]
+			for _, s := range p.stateful {
+				fmt.Println(colorize(fmt.Sprintf("  %s: %s", name, s), colorYellow))
+			}
+			for _, w := range p.warnings {
+				fmt.Println(colorize(fmt.Sprintf("  warning: %s: %s", name, w), colorYellow))
+			}
+		}
+	}
+	for _, blocker := range b.blockers {
+		fmt.Println(colorize("  "+blocker, colorRed))
+	}
+	fmt.Println()
+}
+
+// bulkDeleteResult is the outcome of deleting one stack in a bulk delete.
+type bulkDeleteResult struct {
+	stack    string
+	wave     int
+	status   string
+	reason   string
+	duration time.Duration
+}
+
+// runBulkDelete deletes several stacks in dependency order.
+func runBulkDelete(names []string, namesFromStdin bool, opts deleteOptions) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+
+	if opts.match != "" || opts.filters.isSet() {
+		stacks, err := matchStacks(ctx, client, opts.match, opts.filters)
+		if err != nil {
+			fatalf("failed to list stacks: %v\n", err)
+		}
+		for _, s := range stacks {
+			names = append(names, getValue(s.StackName))
+		}
+	}
+	names = uniqueStrings(names)
+	if len(names) == 0 {
+		fatalf("no stacks to delete\n")
+	}
+
+	fmt.Fprintf(os.Stderr, "Checking %d stack(s)...\n", len(names))
+	plans := make([]deletePlan, len(names))
+	errs := make([]error, len(names))
+	sem := make(chan struct{}, opts.concurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			plans[i], errs[i] = buildDeletePlan(ctx, client, name, nil)
+		})
+	}
+	wg.Wait()
+	byName := make(map[string]deletePlan)
+	for i, err := range errs {
+		if err != nil {
+			fatalf("failed to check stack %q: %v\n", names[i], err)
+		}
+		byName[names[i]] = plans[i]
+	}
+
+	bulk := planBulkDelete(plans)
+	fmt.Printf("Deletion plan for %d stack(s) in %d wave(s):\n\n", bulk.count(), len(bulk.waves))
+	bulk.print(byName)
+	if len(bulk.blockers) > 0 {
+		fatalf("refusing to delete: %d blocking issue(s)\n", len(bulk.blockers))
+	}
+	if opts.dryRun {
+		return
+	}
+
+	if !opts.yes {
+		in := io.Reader(os.Stdin)
+		if namesFromStdin {
+			tty, err := os.Open("/dev/tty")
+			if err != nil {
+				fatalf("cannot ask for confirmation while reading stack names from stdin; use --yes\n")
+			}
+			defer tty.Close()
+			in = tty
+		}
+		if !confirm(fmt.Sprintf("Delete %d stacks? Type 'yes' to confirm: ", bulk.count()), in) {
+			fatalf("aborted\n")
+		}
+	}
+
+	// Nested stacks are deleted with their ancestor, archive them too
+	for _, name := range bulk.archived() {
+		opts.archive(ctx, client, name)
+	}
+
+	notifier := newNotifier(opts.notify)
+	var results []bulkDeleteResult
+	failed := make(map[string]bool)
+	for i, wave := range bulk.waves {
+		fmt.Printf("Wave %d/%d: deleting %s\n", i+1, len(bulk.waves), strings.Join(wave, ", "))
+		waveResults := make([]bulkDeleteResult, len(wave))
+		for j, name := range wave {
+			wg.Go(func() {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				for _, dep := range bulk.dependsOn[name] {
+					if failed[dep] {
+						waveResults[j] = bulkDeleteResult{stack: name, wave: i + 1, status: "SKIPPED", reason: "still imported by " + dep}
+						return
+					}
+				}
+				r := deleteAndWait(ctx, client, notifier, name, opts.stuckAfter)
+				r.wave = i + 1
+				waveResults[j] = r
+				fmt.Printf("  %s: %s (%s)\n", name, colorize(r.status, colorForCFStatus(r.status)), r.duration.Round(time.Second))
+				notifier.stackStatus(ctx, name, types.StackStatus(r.status), r.reason)
+			})
+		}
+		wg.Wait()
+		for _, r := range waveResults {
+			if r.status != string(types.StackStatusDeleteComplete) {
+				failed[r.stack] = true
+			}
+		}
+		results = append(results, waveResults...)
+	}
+
+	fmt.Println()
+	table := makeTable([]string{"WAVE", "STACK", "RESULT", "DURATION", "REASON"})
+	for _, r := range results {
+		table.Rows = append(table.Rows, v1.TableRow{
+			Cells: []interface{}{r.wave, r.stack, r.status, r.duration.Round(time.Second).String(), r.reason},
+		})
+	}
+	mustPrint(table)
+
+	if len(failed) > 0 {
+		fatalf("\n%d of %d stack(s) were not deleted\n", len(failed), len(results))
+	}
+}
+
+// deleteAndWait deletes a stack and waits until the deletion finishes.
+func deleteAndWait(ctx context.Context, client *cloudformation.Client, notifier *notifier, stackName string, stuckAfter time.Duration) bulkDeleteResult {
+	start := time.Now()
+	result := bulkDeleteResult{stack: stackName}
+	finish := func(status, reason string) bulkDeleteResult {
+		result.status, result.reason, result.duration = status, reason, time.Since(start)
+		return result
+	}
+
+	watcher := newDeleteWatcher(ctx, client, notifier, stackName, stuckAfter)
+	if _, err := client.DeleteStack(ctx, &cloudformation.DeleteStackInput{StackName: &stackName}); err != nil {
+		return finish("ERROR", err.Error())
+	}
+	for {
+		time.Sleep(5 * time.Second)
+		watcher.poll(ctx)
+		stack, err := describeStack(ctx, client, stackName)
+		if err != nil {
+			if isStackNotFound(err) {
+				return finish(string(types.StackStatusDeleteComplete), "")
+			}
+			return finish("ERROR", err.Error())
+		}
+		switch stack.StackStatus {
+		case types.StackStatusDeleteComplete:
+			return finish(string(stack.StackStatus), "")
+		case types.StackStatusDeleteFailed:
+			watcher.poll(ctx)
+			return finish(string(stack.StackStatus), getValue(stack.StackStatusReason))
+		}
+	}
+}
+
+// readStackNames reads whitespace-separated stack names, as printed by
+// 'cfn list -1'. Lines starting with # are ignored.
+func readStackNames(r io.Reader) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, strings.Fields(line)...)
+	}
+	return names, scanner.Err()
+}
+
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
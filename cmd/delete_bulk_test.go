@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testStackARN = "arn:aws:cloudformation:eu-west-1:123456789012:stack/%s/abc"
+
+func TestPlanBulkDeleteWaves(t *testing.T) {
+	plans := []deletePlan{
+		{stackName: "network", imports: map[string][]string{"VpcId": {"database", "app"}}},
+		{stackName: "database", imports: map[string][]string{"DbHost": {"app"}}},
+		{stackName: "app"},
+		{stackName: "cache"},
+	}
+
+	bulk := planBulkDelete(plans)
+	if len(bulk.blockers) > 0 {
+		t.Fatalf("unexpected blockers: %v", bulk.blockers)
+	}
+	var got []string
+	for _, wave := range bulk.waves {
+		got = append(got, strings.Join(wave, ","))
+	}
+	want := "app,cache | database | network"
+	if strings.Join(got, " | ") != want {
+		t.Errorf("waves = %s, want %s", strings.Join(got, " | "), want)
+	}
+	if bulk.count() != 4 {
+		t.Errorf("count = %d, want 4", bulk.count())
+	}
+}
+
+func TestPlanBulkDeleteNested(t *testing.T) {
+	plans := []deletePlan{
+		{stackName: "root", imports: map[string][]string{}},
+		{stackName: "root-Child-1", parentID: fmt.Sprintf(testStackARN, "root"), rootID: fmt.Sprintf(testStackARN, "root")},
+		{stackName: "shared", imports: map[string][]string{"TopicArn": {"root-Child-1"}}},
+		{stackName: "other-Child-2", parentID: fmt.Sprintf(testStackARN, "other")},
+	}
+
+	bulk := planBulkDelete(plans)
+	if bulk.nested["root-Child-1"] != "root" {
+		t.Errorf("nested = %v, want root-Child-1 deleted with root", bulk.nested)
+	}
+	if got := strings.Join(bulk.dependsOn["shared"], ","); got != "root" {
+		t.Errorf("shared depends on %q, want root", got)
+	}
+	if len(bulk.blockers) != 1 || !strings.Contains(bulk.blockers[0], "other-Child-2: nested stack of other") {
+		t.Errorf("blockers = %v, want the orphan nested stack", bulk.blockers)
+	}
+	if archived := bulk.archived(); !slices.Contains(archived, "root") || !slices.Contains(archived, "root-Child-1") {
+		t.Errorf("archived = %v, want root and its nested stack", archived)
+	}
+}
+
+func TestPlanBulkDeleteBlockers(t *testing.T) {
+	plans := []deletePlan{
+		{stackName: "a", imports: map[string][]string{"A": {"b"}}},
+		{stackName: "b", imports: map[string][]string{"B": {"a"}}},
+		{stackName: "c", terminationProtected: true, imports: map[string][]string{"C": {"outside"}}},
+	}
+
+	bulk := planBulkDelete(plans)
+	want := []string{
+		"c: termination protection is enabled",
+		"c: export C is imported by outside, which is not being deleted",
+		"circular export/import dependency between a, b",
+	}
+	if strings.Join(bulk.blockers, "\n") != strings.Join(want, "\n") {
+		t.Errorf("blockers = %q, want %q", bulk.blockers, want)
+	}
+}
+
+func TestReadStackNames(t *testing.T) {
+	names, err := readStackNames(strings.NewReader("pr-1-api\n\n# comment\npr-1-db pr-1-web\n  pr-1-api  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(uniqueStrings(names), ","); got != "pr-1-api,pr-1-db,pr-1-web" {
+		t.Errorf("names = %s", got)
+	}
+}
@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// statefulTypes are resource types that hold data which is lost when the
+// resource is deleted, for types whose contents are not inspected.
+var statefulTypes = map[string]string{
+	"AWS::RDS::DBInstance":                 "database instance",
+	"AWS::RDS::DBCluster":                  "database cluster",
+	"AWS::DocDB::DBCluster":                "DocumentDB cluster",
+	"AWS::Neptune::DBCluster":              "Neptune cluster",
+	"AWS::Redshift::Cluster":               "Redshift cluster",
+	"AWS::EFS::FileSystem":                 "EFS file system",
+	"AWS::FSx::FileSystem":                 "FSx file system",
+	"AWS::OpenSearchService::Domain":       "OpenSearch domain",
+	"AWS::Elasticsearch::Domain":           "Elasticsearch domain",
+	"AWS::ElastiCache::ReplicationGroup":   "ElastiCache replication group",
+	"AWS::Kinesis::Stream":                 "Kinesis stream",
+	"AWS::SQS::Queue":                      "SQS queue",
+	"AWS::Backup::BackupVault":             "backup vault",
+	"AWS::DynamoDB::GlobalTable":           "DynamoDB global table",
+	"AWS::Timestream::Table":               "Timestream table",
+	"AWS::KinesisFirehose::DeliveryStream": "Firehose delivery stream",
+}
+
+// retainedResource is a resource that is kept when the stack is deleted.
+type retainedResource struct {
+	logicalID    string
+	resourceType string
+	policy       string
+}
+
+// deletePlan is the result of the pre-delete checks of a stack.
+type deletePlan struct {
+	stackName            string
+	terminationProtected bool
+	parentID             string
+	rootID               string
+	exports              []string
+	imports              map[string][]string // export name -> importing stacks
+	retained             []retainedResource
+	stateful             []string // resources whose data would be lost
+	warnings             []string // checks that could not be completed
+}
+
+// blockers returns the reasons the stack must not be deleted.
+func (p deletePlan) blockers() []string {
+	var blockers []string
+	if p.terminationProtected {
+		blockers = append(blockers, "termination protection is enabled (disable it with 'aws cloudformation update-termination-protection --no-enable-termination-protection')")
+	}
+	if p.parentID != "" {
+		blockers = append(blockers, fmt.Sprintf("this is a nested stack of %s; remove it from the parent template or delete the parent instead", stackNameFromARN(p.parentID)))
+	}
+	exports := make([]string, 0, len(p.imports))
+	for export := range p.imports {
+		exports = append(exports, export)
+	}
+	sort.Strings(exports)
+	for _, export := range exports {
+		blockers = append(blockers, fmt.Sprintf("export %s is imported by %s", export, strings.Join(p.imports[export], ", ")))
+	}
+	return blockers
+}
+
+func (p deletePlan) print(w io.Writer) {
+	fmt.Fprintf(w, "Pre-delete plan for stack %q:\n", p.stackName)
+
+	protection := "disabled"
+	if p.terminationProtected {
+		protection = colorize("enabled", colorRed)
+	}
+	fmt.Fprintf(w, "  Termination protection: %s\n", protection)
+	if p.parentID != "" {
+		fmt.Fprintf(w, "  Parent stack:           %s\n", colorize(stackNameFromARN(p.parentID), colorRed))
+	}
+	switch {
+	case len(p.exports) == 0:
+		fmt.Fprintf(w, "  Exports:                none\n")
+	case len(p.imports) == 0:
+		fmt.Fprintf(w, "  Exports:                %d, none imported\n", len(p.exports))
+	default:
+		fmt.Fprintf(w, "  Exports:                %d, %s\n", len(p.exports), colorize(fmt.Sprintf("%d still imported", len(p.imports)), colorRed))
+	}
+
+	if len(p.retained) > 0 {
+		fmt.Fprintf(w, "\n  Resources kept after deletion:\n")
+		for _, r := range p.retained {
+			fmt.Fprintf(w, "    %-40s %-40s %s\n", r.logicalID, r.resourceType, r.policy)
+		}
+	}
+	if len(p.stateful) > 0 {
+		fmt.Fprintf(w, "\n  Resources whose data will be deleted:\n")
+		for _, s := range p.stateful {
+			fmt.Fprintf(w, "    %s\n", colorize(s, colorYellow))
+		}
+	}
+	for _, warning := range p.warnings {
+		fmt.Fprintf(w, "  %s\n", colorize("warning: "+warning, colorYellow))
+	}
+	if blockers := p.blockers(); len(blockers) > 0 {
+		fmt.Fprintf(w, "\n  Blocking issues:\n")
+		for _, b := range blockers {
+			fmt.Fprintf(w, "    %s\n", colorize(b, colorRed))
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// buildDeletePlan runs the pre-delete checks of a stack. Checks that fail are
+// recorded as warnings; only a missing stack is an error.
+func buildDeletePlan(ctx context.Context, client *cloudformation.Client, stackName string, retainResources []string) (deletePlan, error) {
+	plan := deletePlan{stackName: stackName, imports: make(map[string][]string)}
+
+	stack, err := describeStack(ctx, client, stackName)
+	if err != nil {
+		return plan, err
+	}
+	plan.terminationProtected = aws.ToBool(stack.EnableTerminationProtection)
+	plan.parentID = getValue(stack.ParentId)
+	plan.rootID = getValue(stack.RootId)
+
+	for _, o := range stack.Outputs {
+		export := getValue(o.ExportName)
+		if export == "" {
+			continue
+		}
+		plan.exports = append(plan.exports, export)
+		importers, err := listImports(ctx, client, export)
+		if err != nil {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("failed to list imports of %s: %v", export, err))
+			continue
+		}
+		if len(importers) > 0 {
+			plan.imports[export] = importers
+		}
+	}
+
+	out, err := client.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &stackName,
+		TemplateStage: types.TemplateStageProcessed,
+	})
+	if err != nil {
+		plan.warnings = append(plan.warnings, fmt.Sprintf("failed to get template: %v", err))
+	} else if template, err := parseTemplateBody(getValue(out.TemplateBody)); err != nil {
+		plan.warnings = append(plan.warnings, fmt.Sprintf("failed to parse template: %v", err))
+	} else {
+		plan.retained = retainedResources(template, retainResources)
+	}
+
+	resources, err := listStackResources(ctx, client, stackName)
+	if err != nil {
+		plan.warnings = append(plan.warnings, fmt.Sprintf("failed to list resources: %v", err))
+		return plan, nil
+	}
+	kept := make(map[string]bool)
+	for _, r := range plan.retained {
+		kept[r.logicalID] = true
+	}
+	checker := newStatefulChecker(ctx)
+	for _, r := range resources {
+		if kept[getValue(r.LogicalResourceId)] || r.ResourceStatus == types.ResourceStatusDeleteComplete {
+			continue
+		}
+		note, err := checker.check(ctx, r)
+		if err != nil {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("failed to check %s: %v", getValue(r.LogicalResourceId), err))
+			continue
+		}
+		if note != "" {
+			plan.stateful = append(plan.stateful, fmt.Sprintf("%s (%s): %s", getValue(r.LogicalResourceId), getValue(r.ResourceType), note))
+		}
+	}
+	return plan, nil
+}
+
+// listImports returns the stacks importing an export.
+func listImports(ctx context.Context, client *cloudformation.Client, export string) ([]string, error) {
+	var importers []string
+	paginator := cloudformation.NewListImportsPaginator(client, &cloudformation.ListImportsInput{ExportName: &export})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			if isNotImported(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		importers = append(importers, out.Imports...)
+	}
+	return importers, nil
+}
+
+// isNotImported reports whether ListImports failed because nothing imports
+// the export, which the API reports as a validation error.
+func isNotImported(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return strings.Contains(apiErr.ErrorMessage(), "is not imported")
+}
+
+// retainedResources lists the template resources kept when the stack is
+// deleted: those with a Retain or Snapshot DeletionPolicy and those passed
+// with --retain-resource.
+func retainedResources(template map[string]interface{}, retainResources []string) []retainedResource {
+	resources, _ := template["Resources"].(map[string]interface{})
+	ids := make([]string, 0, len(resources))
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var retained []retainedResource
+	for _, id := range ids {
+		res, _ := resources[id].(map[string]interface{})
+		resourceType, _ := res["Type"].(string)
+		policy, _ := res["DeletionPolicy"].(string)
+		switch {
+		case slices.Contains(retainResources, id):
+			retained = append(retained, retainedResource{id, resourceType, "--retain-resource"})
+		case policy == "Retain", policy == "RetainExceptOnCreate", policy == "Snapshot":
+			retained = append(retained, retainedResource{id, resourceType, "DeletionPolicy: " + policy})
+		}
+	}
+	return retained
+}
+
+// statefulChecker inspects resources that may still hold data. Service
+// clients are created on first use.
+type statefulChecker struct {
+	cfg    aws.Config
+	s3     *s3.Client
+	dynamo *dynamodb.Client
+	ecr    *ecr.Client
+}
+
+func newStatefulChecker(ctx context.Context) *statefulChecker {
+	return &statefulChecker{cfg: mustConfig(ctx)}
+}
+
+// check returns a note about the data a resource holds, or "" if it is empty
+// or stateless.
+func (c *statefulChecker) check(ctx context.Context, r types.StackResourceSummary) (string, error) {
+	id := getValue(r.PhysicalResourceId)
+	resourceType := getValue(r.ResourceType)
+	if id == "" {
+		return "", nil
+	}
+
+	switch resourceType {
+	case "AWS::S3::Bucket":
+		if c.s3 == nil {
+			c.s3 = s3.NewFromConfig(c.cfg)
+		}
+		out, err := c.s3.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{Bucket: &id, MaxKeys: aws.Int32(1)})
+		if err != nil {
+			return "", err
+		}
+		if len(out.Versions) > 0 || len(out.DeleteMarkers) > 0 {
+			return "bucket is not empty; deletion fails until it is emptied", nil
+		}
+	case "AWS::DynamoDB::Table":
+		if c.dynamo == nil {
+			c.dynamo = dynamodb.NewFromConfig(c.cfg)
+		}
+		out, err := c.dynamo.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &id})
+		if err != nil {
+			return "", err
+		}
+		if n := aws.ToInt64(out.Table.ItemCount); n > 0 {
+			return fmt.Sprintf("table holds about %d items", n), nil
+		}
+	case "AWS::ECR::Repository":
+		if c.ecr == nil {
+			c.ecr = ecr.NewFromConfig(c.cfg)
+		}
+		out, err := c.ecr.DescribeImages(ctx, &ecr.DescribeImagesInput{RepositoryName: &id, MaxResults: aws.Int32(1)})
+		if err != nil {
+			return "", err
+		}
+		if len(out.ImageDetails) > 0 {
+			return "repository contains images; deletion fails unless EmptyOnDelete is set", nil
+		}
+	default:
+		if kind, ok := statefulTypes[resourceType]; ok {
+			return kind + " and its data will be deleted", nil
+		}
+	}
+	return "", nil
+}
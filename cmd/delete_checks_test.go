@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRetainedResources(t *testing.T) {
+	template, err := parseTemplateBody(`
+Resources:
+  Logs:
+    Type: AWS::S3::Bucket
+    DeletionPolicy: Retain
+  Database:
+    Type: AWS::RDS::DBInstance
+    DeletionPolicy: Snapshot
+  Queue:
+    Type: AWS::SQS::Queue
+  Topic:
+    Type: AWS::SNS::Topic
+    DeletionPolicy: Delete
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retained := retainedResources(template, []string{"Queue"})
+	var got []string
+	for _, r := range retained {
+		got = append(got, r.logicalID+"="+r.policy)
+	}
+	want := "Database=DeletionPolicy: Snapshot,Logs=DeletionPolicy: Retain,Queue=--retain-resource"
+	if strings.Join(got, ",") != want {
+		t.Errorf("retained = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+func TestDeletePlanBlockers(t *testing.T) {
+	plan := deletePlan{stackName: "network", imports: map[string][]string{}}
+	if len(plan.blockers()) != 0 {
+		t.Errorf("expected no blockers, got %v", plan.blockers())
+	}
+
+	plan.terminationProtected = true
+	plan.parentID = "arn:aws:cloudformation:eu-west-1:123456789012:stack/root/abc"
+	plan.exports = []string{"network-VpcId", "network-SubnetIds"}
+	plan.imports["network-VpcId"] = []string{"app", "db"}
+
+	blockers := plan.blockers()
+	if len(blockers) != 3 {
+		t.Fatalf("blockers = %v, want 3", blockers)
+	}
+	if !strings.Contains(blockers[1], "nested stack of root") {
+		t.Errorf("unexpected nested stack blocker %q", blockers[1])
+	}
+	if blockers[2] != "export network-VpcId is imported by app, db" {
+		t.Errorf("unexpected import blocker %q", blockers[2])
+	}
+
+	var buf bytes.Buffer
+	plan.print(&buf)
+	for _, want := range []string{"Termination protection: enabled", "Exports:                2, 1 still imported", "Blocking issues:"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("plan output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deleteFailedResources returns the resources that failed to delete and are
+// not already retained.
+func deleteFailedResources(resources []types.StackResourceSummary, retained []string) []types.StackResourceSummary {
+	var failed []types.StackResourceSummary
+	for _, r := range resources {
+		if r.ResourceStatus == types.ResourceStatusDeleteFailed && !slices.Contains(retained, getValue(r.LogicalResourceId)) {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// retryDeleteRetaining restarts the deletion of a DELETE_FAILED stack,
+// retaining the resources that failed to delete. It returns the resources
+// left behind, or nil if there is nothing new to retain or the retry was not
+// confirmed.
+func retryDeleteRetaining(ctx context.Context, client *cloudformation.Client, stackName string, retainResources []string, yes bool) []types.StackResourceSummary {
+	resources, err := listStackResources(ctx, client, stackName)
+	if err != nil {
+		fatalf("failed to list resources for stack %q: %v\n", stackName, err)
+	}
+	failed := deleteFailedResources(resources, retainResources)
+	if len(failed) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Resources in DELETE_FAILED state:\n")
+	for _, r := range failed {
+		reason := getValue(r.ResourceStatusReason)
+		if reason != "" {
+			fmt.Fprintf(os.Stderr, "  %s (%s) — %s\n", getValue(r.LogicalResourceId), getValue(r.ResourceType), reason)
+		} else {
+			fmt.Fprintf(os.Stderr, "  %s (%s)\n", getValue(r.LogicalResourceId), getValue(r.ResourceType))
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if !yes && !confirm(fmt.Sprintf("Retry deleting stack %q, retaining these %d resource(s)? Type 'yes' to confirm: ", stackName, len(failed)), os.Stdin) {
+		return nil
+	}
+
+	retain := slices.Clone(retainResources)
+	for _, r := range failed {
+		retain = append(retain, getValue(r.LogicalResourceId))
+	}
+	if _, err := client.DeleteStack(ctx, &cloudformation.DeleteStackInput{StackName: &stackName, RetainResources: retain}); err != nil {
+		fatalf("failed to delete stack %q: %v\n", stackName, err)
+	}
+	fmt.Printf("Deletion restarted for stack %q, retaining %d resource(s)\n", stackName, len(failed))
+	return failed
+}
+
+// printOrphans reports the resources left behind by --auto-retain or --force,
+// which still exist and must be cleaned up by hand.
+func printOrphans(orphans []types.StackResourceSummary) {
+	if len(orphans) == 0 {
+		return
+	}
+	fmt.Printf("\n%d resource(s) were left behind and still exist outside of CloudFormation:\n\n", len(orphans))
+	table := makeTable([]string{"LOGICAL ID", "TYPE", "PHYSICAL ID", "REASON"})
+	for _, r := range orphans {
+		table.Rows = append(table.Rows, v1.TableRow{
+			Cells: []interface{}{
+				getValue(r.LogicalResourceId),
+				getValue(r.ResourceType),
+				getValue(r.PhysicalResourceId),
+				getValue(r.ResourceStatusReason),
+			},
+		})
+	}
+	mustPrint(table)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestDeleteFailedResources(t *testing.T) {
+	resources := []types.StackResourceSummary{
+		{LogicalResourceId: aws.String("Bucket"), ResourceStatus: types.ResourceStatusDeleteFailed},
+		{LogicalResourceId: aws.String("Role"), ResourceStatus: types.ResourceStatusDeleteComplete},
+		{LogicalResourceId: aws.String("Queue"), ResourceStatus: types.ResourceStatusDeleteFailed},
+		{LogicalResourceId: aws.String("Topic"), ResourceStatus: types.ResourceStatusDeleteFailed},
+	}
+
+	var got []string
+	for _, r := range deleteFailedResources(resources, []string{"Queue"}) {
+		got = append(got, getValue(r.LogicalResourceId))
+	}
+	if strings.Join(got, ",") != "Bucket,Topic" {
+		t.Errorf("failed = %v, want Bucket,Topic", got)
+	}
+}
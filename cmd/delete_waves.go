@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudcontrol"
+	cctypes "github.com/aws/aws-sdk-go-v2/service/cloudcontrol/types"
+	"gopkg.in/yaml.v3"
+)
+
+// ccMaxRounds is how many times resources that failed, or whose dependents
+// failed, are retried through Cloud Control.
+const ccMaxRounds = 3
+
+// ccTarget is a stack resource deleted through Cloud Control.
+type ccTarget struct {
+	logicalID  string
+	physicalID string
+	typeName   string
+}
+
+// subVariable matches the ${Name} and ${Name.Attribute} variables of Fn::Sub,
+// but not the ${!Literal} escapes.
+var subVariable = regexp.MustCompile(`\$\{([^!}][^}]*)\}`)
+
+// templateDependencies returns, for each resource of a template, the
+// resources it references through Ref, Fn::GetAtt, Fn::Sub or DependsOn.
+func templateDependencies(doc *yaml.Node) map[string][]string {
+	resources := mappingValue(templateRoot(doc), "Resources")
+	if resources == nil || resources.Kind != yaml.MappingNode {
+		return nil
+	}
+	ids := make(map[string]bool)
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		ids[resources.Content[i].Value] = true
+	}
+
+	deps := make(map[string][]string)
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		id := resources.Content[i].Value
+		seen := map[string]bool{id: true}
+		add := func(name string) {
+			if ids[name] && !seen[name] {
+				seen[name] = true
+				deps[id] = append(deps[id], name)
+			}
+		}
+
+		resource := resources.Content[i+1]
+		if dependsOn := mappingValue(resource, "DependsOn"); dependsOn != nil {
+			if dependsOn.Kind == yaml.SequenceNode {
+				for _, d := range dependsOn.Content {
+					add(d.Value)
+				}
+			} else {
+				add(dependsOn.Value)
+			}
+		}
+		collectReferences(resource, add)
+		sort.Strings(deps[id])
+	}
+	return deps
+}
+
+// collectReferences calls add with the name of every resource or parameter
+// referenced below n.
+func collectReferences(n *yaml.Node, add func(string)) {
+	if n == nil {
+		return
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	name := intrinsicName(n)
+	value := n
+	if name != "" && !strings.HasPrefix(name, "!") {
+		value = n.Content[1]
+	}
+	switch name {
+	case "!Ref", "Ref":
+		add(value.Value)
+		return
+	case "!GetAtt", "Fn::GetAtt":
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+			add(value.Content[0].Value)
+		} else {
+			name, _, _ := strings.Cut(value.Value, ".")
+			add(name)
+		}
+		return
+	case "!Sub", "Fn::Sub":
+		format := value
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+			format = value.Content[0]
+			for _, v := range value.Content[1:] {
+				collectReferences(v, add)
+			}
+		}
+		for _, m := range subVariable.FindAllStringSubmatch(format.Value, -1) {
+			name, _, _ := strings.Cut(m[1], ".")
+			add(strings.TrimSpace(name))
+		}
+		return
+	}
+	for _, c := range n.Content {
+		collectReferences(c, add)
+	}
+}
+
+// deletionBlockers returns, for each target, the targets that depend on it
+// directly or through resources that are not targets, and so have to be
+// deleted first.
+func deletionBlockers(targets []string, deps map[string][]string) map[string][]string {
+	isTarget := make(map[string]bool)
+	for _, t := range targets {
+		isTarget[t] = true
+	}
+	dependents := make(map[string][]string)
+	for id, list := range deps {
+		for _, d := range list {
+			dependents[d] = append(dependents[d], id)
+		}
+	}
+
+	blockers := make(map[string][]string)
+	for _, t := range targets {
+		visited := map[string]bool{t: true}
+		queue := slices.Clone(dependents[t])
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			if isTarget[id] {
+				blockers[t] = append(blockers[t], id)
+				continue
+			}
+			queue = append(queue, dependents[id]...)
+		}
+		sort.Strings(blockers[t])
+	}
+	return blockers
+}
+
+// deletionWaves orders resources for deletion: a resource is in a later wave
+// than every resource that depends on it, directly or through resources that
+// are not deleted. Resources are sorted within each wave.
+func deletionWaves(targets []string, deps map[string][]string) [][]string {
+	blocking := deletionBlockers(targets, deps)
+	var waves [][]string
+	deleted := make(map[string]bool)
+	for len(deleted) < len(targets) {
+		var wave []string
+		for _, t := range targets {
+			if deleted[t] {
+				continue
+			}
+			ready := true
+			for _, b := range blocking[t] {
+				if !deleted[b] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, t)
+			}
+		}
+		if len(wave) == 0 {
+			// Templates cannot have circular dependencies; delete what is
+			// left together rather than not at all.
+			for _, t := range targets {
+				if !deleted[t] {
+					wave = append(wave, t)
+				}
+			}
+		}
+		sort.Strings(wave)
+		for _, t := range wave {
+			deleted[t] = true
+		}
+		waves = append(waves, wave)
+	}
+	return waves
+}
+
+// deleteInWaves deletes the targets through Cloud Control, wave by wave, with
+// up to concurrency deletions at a time. A resource is only attempted once
+// every resource depending on it is deleted; failed and postponed resources
+// are retried in later rounds while some progress is made.
+func deleteInWaves(ctx context.Context, cc *cloudcontrol.Client, targets []ccTarget, deps map[string][]string, concurrency int) []ccTarget {
+	byID := make(map[string]ccTarget)
+	for _, t := range targets {
+		byID[t.logicalID] = t
+	}
+
+	pending := targets
+	for round := 1; round <= ccMaxRounds && len(pending) > 0; round++ {
+		if round > 1 {
+			fmt.Printf("Retrying %d resource(s) (round %d/%d)...\n", len(pending), round, ccMaxRounds)
+		}
+		ids := make([]string, len(pending))
+		for i, t := range pending {
+			ids[i] = t.logicalID
+		}
+		waves := deletionWaves(ids, deps)
+		blocking := deletionBlockers(ids, deps)
+
+		var mu sync.Mutex
+		deleted := make(map[string]bool)
+		var remaining []ccTarget
+		for i, wave := range waves {
+			fmt.Printf("Wave %d/%d: %s\n", i+1, len(waves), strings.Join(wave, ", "))
+			var ready []ccTarget
+			for _, id := range wave {
+				var waiting []string
+				for _, b := range blocking[id] {
+					if !deleted[b] {
+						waiting = append(waiting, b)
+					}
+				}
+				if len(waiting) > 0 {
+					fmt.Printf("  %s: postponed until %s is deleted\n", id, strings.Join(waiting, ", "))
+					remaining = append(remaining, byID[id])
+					continue
+				}
+				ready = append(ready, byID[id])
+			}
+
+			sem := make(chan struct{}, concurrency)
+			var wg sync.WaitGroup
+			for _, t := range ready {
+				wg.Go(func() {
+					sem <- struct{}{}
+					defer func() { <-sem }()
+
+					err := ccDeleteResource(ctx, cc, t)
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						fmt.Printf("  %s: delete failed: %v\n", t.logicalID, err)
+						remaining = append(remaining, t)
+						return
+					}
+					fmt.Printf("  %s: deleted\n", t.logicalID)
+					deleted[t.logicalID] = true
+				})
+			}
+			wg.Wait()
+		}
+		if len(deleted) == 0 {
+			return remaining
+		}
+		pending = remaining
+	}
+	return pending
+}
+
+// ccDeleteResource deletes a resource through Cloud Control and waits for
+// the request to finish. A resource that no longer exists counts as deleted.
+func ccDeleteResource(ctx context.Context, cc *cloudcontrol.Client, t ccTarget) error {
+	out, err := cc.DeleteResource(ctx, &cloudcontrol.DeleteResourceInput{
+		TypeName:   &t.typeName,
+		Identifier: &t.physicalID,
+	})
+	if err != nil {
+		var notFound *cctypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	token := getValue(out.ProgressEvent.RequestToken)
+	if token == "" {
+		return nil
+	}
+
+	for {
+		time.Sleep(3 * time.Second)
+		status, err := cc.GetResourceRequestStatus(ctx, &cloudcontrol.GetResourceRequestStatusInput{
+			RequestToken: &token,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to poll status: %w", err)
+		}
+		event := status.ProgressEvent
+		switch event.OperationStatus {
+		case cctypes.OperationStatusSuccess:
+			return nil
+		case cctypes.OperationStatusFailed:
+			if event.ErrorCode == cctypes.HandlerErrorCodeNotFound {
+				return nil
+			}
+			return errors.New(getValue(event.StatusMessage))
+		case cctypes.OperationStatusCancelComplete:
+			return errors.New("cancelled")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTemplateDependencies(t *testing.T) {
+	doc, err := parseTemplateNode(`
+Parameters:
+  Env:
+    Type: String
+Resources:
+  Vpc:
+    Type: AWS::EC2::VPC
+  Subnet:
+    Type: AWS::EC2::Subnet
+    Properties:
+      VpcId: !Ref Vpc
+      Tags:
+        - Key: Name
+          Value: !Sub "${Env}-${Vpc}-${!Literal}"
+  Role:
+    Type: AWS::IAM::Role
+  Policy:
+    Type: AWS::IAM::Policy
+    Properties:
+      Roles: [{"Ref": "Role"}]
+  Function:
+    Type: AWS::Lambda::Function
+    DependsOn: [Policy]
+    Properties:
+      Role: !GetAtt Role.Arn
+      VpcConfig:
+        SubnetIds:
+          - {"Fn::GetAtt": ["Subnet", "SubnetId"]}
+      Environment:
+        Variables:
+          URL: !Sub
+            - "https://${Host}/${Vpc.CidrBlock}"
+            - Host: !Ref Subnet
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps := templateDependencies(doc)
+	want := map[string]string{
+		"Subnet":   "Vpc",
+		"Policy":   "Role",
+		"Function": "Policy,Role,Subnet,Vpc",
+	}
+	for id, w := range want {
+		if got := strings.Join(deps[id], ","); got != w {
+			t.Errorf("deps[%s] = %s, want %s", id, got, w)
+		}
+	}
+	if len(deps["Vpc"]) > 0 || len(deps["Role"]) > 0 {
+		t.Errorf("unexpected dependencies: %v", deps)
+	}
+}
+
+func TestDeletionWaves(t *testing.T) {
+	deps := map[string][]string{
+		"Subnet":   {"Vpc"},
+		"Policy":   {"Role"},
+		"Function": {"Policy", "Role", "Subnet"},
+		"Alias":    {"Nested"},
+		"Nested":   {"Bucket"},
+	}
+
+	tests := []struct {
+		name    string
+		targets []string
+		want    string
+	}{
+		{"chain", []string{"Vpc", "Subnet", "Role", "Policy", "Function"}, "[Function] [Policy Subnet] [Role Vpc]"},
+		{"through non-target", []string{"Alias", "Bucket"}, "[Alias] [Bucket]"},
+		{"independent", []string{"Vpc", "Role"}, "[Role Vpc]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fmt.Sprint(deletionWaves(tt.targets, deps))
+			if got != "["+tt.want+"]" {
+				t.Errorf("waves = %s, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
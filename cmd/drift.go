@@ -2,41 +2,249 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// driftOptions holds the flags of the drift command.
+type driftOptions struct {
+	wait        bool
+	match       string
+	filters     stackFilters
+	concurrency int
+	output      string
+	cached      bool
+	maxAge      time.Duration
+	properties  []string
+	sideBySide  bool
+	resources   []string
+	emitPatch   string
+	patchFormat string
+	recursive   bool
+}
+
+func (o driftOptions) matching() bool {
+	return o.match != "" || o.filters.isSet()
+}
+
+// reuse reports whether the stored drift results described by info are recent
+// enough to be shown instead of starting a new detection.
+func (o driftOptions) reuse(info *types.StackDriftInformation, now time.Time) bool {
+	if !o.cached && o.maxAge == 0 {
+		return false
+	}
+	if info == nil || info.LastCheckTimestamp == nil || info.StackDriftStatus == types.StackDriftStatusNotChecked {
+		return false
+	}
+	return o.maxAge == 0 || now.Sub(*info.LastCheckTimestamp) <= o.maxAge
+}
+
+// detect reports whether a new detection may be started when the stored
+// results cannot be reused. --cached on its own never starts one.
+func (o driftOptions) detect() bool {
+	return !o.cached || o.maxAge > 0
+}
+
+// driftAttachTimeout bounds how long to wait for a drift detection started by
+// someone else, since its ID cannot be looked up.
+const driftAttachTimeout = 30 * time.Minute
+
 func DriftCmd() *cobra.Command {
-	var wait bool
+	var opts driftOptions
 
 	cmd := &cobra.Command{
-		Use:   "drift <stack-name>",
+		Use:   "drift [stack-name]",
 		Short: "Detect and show drift for a CloudFormation stack",
-		Args:  cobra.ExactArgs(1),
+		Long: `Detect and show drift for a CloudFormation stack.
+
+Instead of a stack name, --match selects every stack whose name matches a
+glob pattern, narrowed by the same filters as 'cfn list'. Drift detection
+runs on up to --concurrency stacks at a time to stay within API limits, and
+the results are reported as a summary table of every stack followed by the
+drifted resources of each drifted stack. Stacks whose detection fails are
+listed with the error instead of aborting the sweep.
+
+Drift detection takes a while and only one can run per stack. With --cached
+the results of the last detection are shown along with when they were
+checked, without starting a new one. Add --max-age to start a new detection
+only when the stored results are older than that (--max-age alone implies
+--cached). If a detection is already running on a stack, its results are
+awaited instead of failing.
+
+Property differences are parsed as JSON and shown as a structural diff with
+normalized key and list ordering, so reordered policy statements or rules do
+not show up as changes and unchanged siblings are collapsed. --property-path
+narrows the output to some properties (a path selects everything below it,
+and globs are accepted). --side-by-side shows the full expected and actual
+resource models next to each other instead.
+
+Drift detection on a stack does not look inside its nested stacks. With
+--recursive, nested stacks are discovered from the stack resources and
+detected as well, and the summary is shown as a tree with the drift status
+of every stack.
+
+To check a few resources of a large stack, --resource detects drift on just
+those logical IDs (concurrently, up to --concurrency at a time) and shows
+the same property diffs.
+
+To adopt manual changes instead of reverting them, --emit-patch maps every
+property difference back into the deployed template and writes the updated
+template (in its original JSON or YAML format), or with --patch-format
+json-patch an RFC 6902 patch against it. Properties whose template value is
+computed by an intrinsic function (Ref, !Sub, Fn::If...) are never
+overwritten: they are reported as skipped, to be updated by hand.
+
+With --output json or --output markdown the report is written in that format
+instead, for archiving or pasting into a ticket.
+
+Examples:
+  # Detect drift for one stack
+  cfn drift my-stack
+
+  # Weekly sweep over every production stack
+  cfn drift --match 'prod-*' --concurrency 10
+
+  # Show the last results, re-detecting only if they are over a day old
+  cfn drift my-stack --cached --max-age 24h
+
+  # Include nested stacks
+  cfn drift my-stack --recursive
+
+  # Check only one security group of a large stack
+  cfn drift my-stack --resource AppSecurityGroup
+
+  # Only the statements of an IAM policy, or the whole models side by side
+  cfn drift my-stack --property-path /PolicyDocument/Statement
+  cfn drift my-stack --side-by-side
+
+  # Write the template updated with the values changed by hand
+  cfn drift my-stack --emit-patch my-stack.yaml
+  cfn drift my-stack --emit-patch - --patch-format json-patch
+
+  # Sweep stacks by description and save a Markdown report
+  cfn drift --match '*' --desc production -o markdown > drift.md`,
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			runDrift(args[0], wait)
+			if len(args) == 1 && opts.matching() {
+				fatalf("a stack name cannot be combined with --match or stack filters\n")
+			}
+			if len(args) == 0 && !opts.matching() {
+				fatalf("requires a stack name or --match\n")
+			}
+			switch opts.output {
+			case "table", "json", "markdown":
+			default:
+				fatalf("invalid --output %q (expected table, json or markdown)\n", opts.output)
+			}
+			if opts.concurrency < 1 {
+				fatalf("--concurrency must be at least 1\n")
+			}
+			if !opts.wait && (opts.matching() || opts.output != "table") {
+				fatalf("--wait=false requires a single stack name and table output\n")
+			}
+			if opts.maxAge < 0 {
+				fatalf("--max-age must not be negative\n")
+			}
+			if opts.emitPatch != "" {
+				if len(args) == 0 {
+					fatalf("--emit-patch requires a stack name\n")
+				}
+				if opts.patchFormat != "template" && opts.patchFormat != "json-patch" {
+					fatalf("invalid --patch-format %q (expected template or json-patch)\n", opts.patchFormat)
+				}
+			}
+			if len(opts.resources) > 0 {
+				if len(args) == 0 {
+					fatalf("--resource requires a stack name\n")
+				}
+				if opts.cached || opts.maxAge > 0 {
+					fatalf("--resource cannot be combined with --cached or --max-age\n")
+				}
+				runResourceDrift(args[0], opts)
+				return
+			}
+			if opts.recursive && (opts.emitPatch != "" || len(opts.resources) > 0) {
+				fatalf("--recursive cannot be combined with --emit-patch or --resource\n")
+			}
+			if opts.emitPatch != "" {
+				runDriftPatch(args[0], opts)
+				return
+			}
+			if len(args) == 1 && opts.output == "table" && !opts.recursive {
+				runDrift(args[0], opts)
+				return
+			}
+			runDriftSweep(args, opts)
 		},
 	}
 
-	cmd.Flags().BoolVarP(&wait, "wait", "w", true, "Wait for drift detection to complete")
+	cmd.Flags().BoolVarP(&opts.wait, "wait", "w", true, "Wait for drift detection to complete")
+	cmd.Flags().StringVarP(&opts.match, "match", "m", "", "Detect drift on every stack whose name matches this glob pattern")
+	cmd.Flags().IntVar(&opts.concurrency, "concurrency", 5, "Maximum number of concurrent drift detections")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format: table, json or markdown")
+	cmd.Flags().BoolVar(&opts.cached, "cached", false, "Show the results of the last drift detection instead of starting a new one")
+	cmd.Flags().DurationVar(&opts.maxAge, "max-age", 0, "Start a new detection only if the last results are older than this (e.g. 24h)")
+	cmd.Flags().BoolVar(&opts.recursive, "recursive", false, "Also detect drift on nested stacks and show the results as a tree")
+	cmd.Flags().StringArrayVar(&opts.resources, "resource", []string{}, "Only detect drift on this logical resource ID (repeatable)")
+	cmd.Flags().StringArrayVar(&opts.properties, "property-path", []string{}, "Only show differences under this property path, e.g. /PolicyDocument (repeatable, globs allowed)")
+	cmd.Flags().StringVar(&opts.emitPatch, "emit-patch", "", "Write a patch adopting the actual values of drifted properties to this file (- for stdout)")
+	cmd.Flags().StringVar(&opts.patchFormat, "patch-format", "template", "Patch format: template (the updated template) or json-patch (RFC 6902)")
+	cmd.Flags().BoolVar(&opts.sideBySide, "side-by-side", false, "Show the full expected and actual resource models side by side")
+	opts.filters.register(cmd)
 
 	return cmd
 }
 
-func runDrift(stackName string, wait bool) {
+func runDrift(stackName string, opts driftOptions) {
 	ctx := context.Background()
 	client := mustClient(ctx)
 
+	stack, err := describeStack(ctx, client, stackName)
+	if err != nil {
+		fatalf("failed to describe stack %q: %v\n", stackName, err)
+	}
+
+	if opts.reuse(stack.DriftInformation, time.Now()) {
+		printCachedDriftResults(ctx, client, stackName, stack.DriftInformation, opts)
+		return
+	}
+	if !opts.detect() {
+		fmt.Printf("No drift results for stack %q. Run without --cached to detect drift.\n", stackName)
+		return
+	}
+
 	// Initiate detection
 	initOut, err := client.DetectStackDrift(ctx, &cloudformation.DetectStackDriftInput{
 		StackName: &stackName,
 	})
+	if isDriftDetectionInProgress(err) {
+		fmt.Println("Drift detection already in progress")
+		if !opts.wait {
+			return
+		}
+		fmt.Print("Waiting")
+		stack, err = waitForRunningDetection(ctx, client, stackName, stack.DriftInformation, func() { fmt.Print(".") })
+		fmt.Println()
+		if err != nil {
+			fatalf("%v\n", err)
+		}
+		printCachedDriftResults(ctx, client, stackName, stack.DriftInformation, opts)
+		return
+	}
 	if err != nil {
 		fatalf("failed to initiate drift detection for %q: %v\n", stackName, err)
 	}
@@ -44,7 +252,7 @@ func runDrift(stackName string, wait bool) {
 	detectionID := getValue(initOut.StackDriftDetectionId)
 	fmt.Printf("Drift detection started (ID: %s)\n", detectionID)
 
-	if !wait {
+	if !opts.wait {
 		fmt.Println("Use --wait to poll for results automatically.")
 		return
 	}
@@ -65,7 +273,7 @@ func runDrift(stackName string, wait bool) {
 		switch status.DetectionStatus {
 		case types.StackDriftDetectionStatusDetectionComplete:
 			fmt.Println()
-			printDriftResults(ctx, client, stackName, status)
+			printDriftResults(ctx, client, stackName, status.StackDriftStatus, aws.ToInt32(status.DriftedStackResourceCount), opts)
 			return
 		case types.StackDriftDetectionStatusDetectionFailed:
 			fmt.Println()
@@ -75,13 +283,82 @@ func runDrift(stackName string, wait bool) {
 	}
 }
 
-func printDriftResults(ctx context.Context, client *cloudformation.Client, stackName string, status *cloudformation.DescribeStackDriftDetectionStatusOutput) {
-	fmt.Printf("\nStack drift status: %s\n", string(status.StackDriftStatus))
-	fmt.Printf("Drifted resources:  %d\n\n",
-		aws.ToInt32(status.DriftedStackResourceCount),
-	)
+// printCachedDriftResults prints the stored results of the last drift
+// detection of a stack.
+func printCachedDriftResults(ctx context.Context, client *cloudformation.Client, stackName string, info *types.StackDriftInformation, opts driftOptions) {
+	checked := *info.LastCheckTimestamp
+	fmt.Printf("Last checked:       %s (%s ago)\n", checked.Format("2006-01-02 15:04:05"), time.Since(checked).Round(time.Second))
+	printDriftResults(ctx, client, stackName, info.StackDriftStatus, -1, opts)
+}
+
+// printDriftResults prints the drift status and drifted resources of a stack.
+// A negative count is taken from the listed resources.
+func printDriftResults(ctx context.Context, client *cloudformation.Client, stackName string, status types.StackDriftStatus, count int32, opts driftOptions) {
+	drifted, err := listDriftedResources(ctx, client, stackName)
+	if err != nil {
+		fatalf("failed to list drifted resources: %v\n", err)
+	}
+	if count < 0 {
+		count = int32(len(drifted))
+	}
+	filtered := len(opts.properties) > 0 && !opts.sideBySide && len(drifted) > 0
+	if filtered {
+		drifted = filterDriftedResources(drifted, opts.properties)
+		var n int
+		status, n = filteredDriftStatus(status, drifted)
+		count = int32(n)
+	}
+
+	fmt.Printf("\nStack drift status: %s\n", string(status))
+	fmt.Printf("Drifted resources:  %d\n\n", count)
+
+	if len(drifted) == 0 {
+		if filtered {
+			fmt.Println("No differences under the given property paths.")
+		} else {
+			fmt.Println("No drifted resources.")
+		}
+		return
+	}
+	printDriftedResources(drifted, opts)
+}
+
+// isDriftDetectionInProgress reports whether DetectStackDrift failed because
+// a detection is already running on the stack.
+func isDriftDetectionInProgress(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "OperationInProgressException"
+}
+
+// waitForRunningDetection waits for a drift detection that was started
+// elsewhere to finish, which shows as a newer last check timestamp than the
+// one in previous. tick is called on every poll.
+func waitForRunningDetection(ctx context.Context, client *cloudformation.Client, stackName string, previous *types.StackDriftInformation, tick func()) (types.Stack, error) {
+	var since time.Time
+	if previous != nil && previous.LastCheckTimestamp != nil {
+		since = *previous.LastCheckTimestamp
+	}
+	deadline := time.Now().Add(driftAttachTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(3 * time.Second)
+		tick()
+
+		stack, err := describeStack(ctx, client, stackName)
+		if err != nil {
+			return types.Stack{}, fmt.Errorf("failed to describe stack %q: %w", stackName, err)
+		}
+		info := stack.DriftInformation
+		if info != nil && info.LastCheckTimestamp != nil && info.LastCheckTimestamp.After(since) {
+			return stack, nil
+		}
+	}
+	return types.Stack{}, fmt.Errorf("timed out after %s waiting for the running drift detection on %q", driftAttachTimeout, stackName)
+}
 
-	// List drifted resources
+func listDriftedResources(ctx context.Context, client *cloudformation.Client, stackName string) ([]types.StackResourceDrift, error) {
 	var drifted []types.StackResourceDrift
 	paginator := cloudformation.NewDescribeStackResourceDriftsPaginator(client, &cloudformation.DescribeStackResourceDriftsInput{
 		StackName: &stackName,
@@ -94,16 +371,14 @@ func printDriftResults(ctx context.Context, client *cloudformation.Client, stack
 	for paginator.HasMorePages() {
 		output, err := paginator.NextPage(ctx)
 		if err != nil {
-			fatalf("failed to list drifted resources: %v\n", err)
+			return nil, err
 		}
 		drifted = append(drifted, output.StackResourceDrifts...)
 	}
+	return drifted, nil
+}
 
-	if len(drifted) == 0 {
-		fmt.Println("No drifted resources.")
-		return
-	}
-
+func printDriftedResources(drifted []types.StackResourceDrift, opts driftOptions) {
 	table := makeTable([]string{"LOGICAL ID", "TYPE", "DRIFT STATUS", "PROPERTY DIFFS"})
 	for _, d := range drifted {
 		diffs := fmt.Sprintf("%d properties", len(d.PropertyDifferences))
@@ -118,6 +393,14 @@ func printDriftResults(ctx context.Context, client *cloudformation.Client, stack
 	}
 	mustPrint(table)
 
+	if opts.sideBySide {
+		for _, d := range drifted {
+			fmt.Printf("\n%s (%s):\n", getValue(d.LogicalResourceId), getValue(d.ResourceType))
+			printResourceModels(d, opts.properties)
+		}
+		return
+	}
+
 	// Show property-level detail
 	for _, d := range drifted {
 		if len(d.PropertyDifferences) == 0 {
@@ -125,9 +408,429 @@ func printDriftResults(ctx context.Context, client *cloudformation.Client, stack
 		}
 		fmt.Printf("\n%s (%s):\n", getValue(d.LogicalResourceId), getValue(d.ResourceType))
 		for _, diff := range d.PropertyDifferences {
-			fmt.Printf("  %-40s %s\n", getValue(diff.PropertyPath), string(diff.DifferenceType))
-			fmt.Printf("    Expected: %s\n", getValue(diff.ExpectedValue))
-			fmt.Printf("    Actual:   %s\n", getValue(diff.ActualValue))
+			fmt.Printf("  %s (%s)\n", getValue(diff.PropertyPath), string(diff.DifferenceType))
+			lines := diffPropertyValues(parsePropertyValue(getValue(diff.ExpectedValue)), parsePropertyValue(getValue(diff.ActualValue)))
+			printDiffLines(lines, "    ")
 		}
 	}
 }
+
+// runDriftPatch detects drift on a stack, or reuses the stored results, and
+// writes a patch adopting the actual values.
+func runDriftPatch(stackName string, opts driftOptions) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+
+	report := detectStackDrift(ctx, client, stackName, opts)
+	if report.Error != "" {
+		fatalf("%s: %s\n", stackName, report.Error)
+	}
+	if report.DriftStatus == types.StackDriftStatusNotChecked {
+		fatalf("no drift results for stack %q. Run without --cached to detect drift.\n", stackName)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", stackName, report.outcome())
+	emitDriftPatch(ctx, client, report, opts)
+}
+
+// runResourceDrift detects drift on individual resources of a stack with
+// DetectStackResourceDrift.
+func runResourceDrift(stackName string, opts driftOptions) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+
+	results := make([]types.StackResourceDrift, len(opts.resources))
+	errs := make([]error, len(opts.resources))
+	sem := make(chan struct{}, opts.concurrency)
+	var wg sync.WaitGroup
+	for i, logicalID := range opts.resources {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			out, err := client.DetectStackResourceDrift(ctx, &cloudformation.DetectStackResourceDriftInput{
+				StackName:         &stackName,
+				LogicalResourceId: &logicalID,
+			})
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", logicalID, err)
+				return
+			}
+			if out.StackResourceDrift == nil {
+				errs[i] = fmt.Errorf("%s: no drift result returned", logicalID)
+				return
+			}
+			results[i] = *out.StackResourceDrift
+		})
+	}
+	wg.Wait()
+
+	report := resourceDriftReport(stackName, results, errs)
+
+	if len(opts.properties) > 0 && !opts.sideBySide {
+		report.filterProperties(opts.properties)
+	}
+
+	if opts.emitPatch != "" {
+		if report.Error != "" {
+			fatalf("failed to detect drift for some resources: %s\n", report.Error)
+		}
+		emitDriftPatch(ctx, client, report, opts)
+		return
+	}
+
+	switch opts.output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode([]driftReport{report}); err != nil {
+			fatalf("failed to encode report: %v\n", err)
+		}
+	case "markdown":
+		writeDriftMarkdown(os.Stdout, []driftReport{report})
+	default:
+		if len(report.Resources) > 0 {
+			printDriftedResources(report.Resources, opts)
+		}
+	}
+
+	if report.Error != "" {
+		fatalf("failed to detect drift for some resources: %s\n", report.Error)
+	}
+}
+
+// resourceDriftReport builds the report of a --resource detection from the
+// per-resource results. The stack counts as drifted if any resource is.
+func resourceDriftReport(stackName string, results []types.StackResourceDrift, errs []error) driftReport {
+	report := driftReport{Stack: stackName, DriftStatus: types.StackDriftStatusInSync}
+	var failed []string
+	for i, r := range results {
+		if errs[i] != nil {
+			failed = append(failed, errs[i].Error())
+			continue
+		}
+		report.Resources = append(report.Resources, r)
+		switch r.StackResourceDriftStatus {
+		case types.StackResourceDriftStatusModified, types.StackResourceDriftStatusDeleted:
+			report.DriftStatus = types.StackDriftStatusDrifted
+			report.DriftedResources++
+		}
+	}
+	report.Error = strings.Join(failed, "; ")
+	return report
+}
+
+// filterProperties narrows the resources of the report to the
+// --property-path filters and counts only the drifted resources left.
+func (r *driftReport) filterProperties(filters []string) {
+	r.Resources = filterDriftedResources(r.Resources, filters)
+	r.DriftStatus, r.DriftedResources = filteredDriftStatus(r.DriftStatus, r.Resources)
+}
+
+// filteredDriftStatus returns the drift status and count of drifted resources
+// of a stack narrowed to resources. A drifted stack is in sync if none of
+// them drifted; other statuses are kept.
+func filteredDriftStatus(status types.StackDriftStatus, resources []types.StackResourceDrift) (types.StackDriftStatus, int) {
+	count := 0
+	for _, r := range resources {
+		switch r.StackResourceDriftStatus {
+		case types.StackResourceDriftStatusModified, types.StackResourceDriftStatusDeleted:
+			count++
+		}
+	}
+	if status == types.StackDriftStatusDrifted && count == 0 {
+		status = types.StackDriftStatusInSync
+	}
+	return status, count
+}
+
+// driftReport is the drift detection result of one stack in a sweep.
+type driftReport struct {
+	Stack            string                     `json:"stack"`
+	DriftStatus      types.StackDriftStatus     `json:"driftStatus,omitempty"`
+	DriftedResources int                        `json:"driftedResources"`
+	Parent           string                     `json:"parent,omitempty"`
+	LogicalID        string                     `json:"logicalResourceId,omitempty"`
+	LastChecked      *time.Time                 `json:"lastChecked,omitempty"`
+	Cached           bool                       `json:"cached"`
+	Resources        []types.StackResourceDrift `json:"resources,omitempty"`
+	Error            string                     `json:"error,omitempty"`
+
+	depth int // nesting depth with --recursive
+}
+
+// runDriftSweep detects drift on the named or matching stacks concurrently
+// and prints a consolidated report.
+func runDriftSweep(names []string, opts driftOptions) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+
+	if opts.matching() {
+		stacks, err := matchStacks(ctx, client, opts.match, opts.filters)
+		if err != nil {
+			fatalf("failed to list stacks: %v\n", err)
+		}
+		for _, s := range stacks {
+			names = append(names, getValue(s.StackName))
+		}
+	}
+	if len(names) == 0 {
+		fatalf("no stacks match\n")
+	}
+	sort.Strings(names)
+
+	var targets []driftTarget
+	if opts.recursive {
+		targets = discoverNestedStacks(ctx, client, names, opts.concurrency)
+	} else {
+		for _, name := range names {
+			targets = append(targets, driftTarget{stack: name})
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Detecting drift on %d stack(s), %d at a time\n", len(targets), opts.concurrency)
+
+	reports := make([]driftReport, len(targets))
+	sem := make(chan struct{}, opts.concurrency)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	for i, t := range targets {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r := detectStackDrift(ctx, client, t.stack, opts)
+			r.Parent, r.LogicalID, r.depth = t.parent, t.logicalID, t.depth
+			reports[i] = r
+
+			mu.Lock()
+			done++
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s\n", done, len(targets), t.stack, r.outcome())
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	if len(opts.properties) > 0 && !opts.sideBySide {
+		for i := range reports {
+			reports[i].filterProperties(opts.properties)
+		}
+	}
+
+	switch opts.output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			fatalf("failed to encode report: %v\n", err)
+		}
+	case "markdown":
+		writeDriftMarkdown(os.Stdout, reports)
+	default:
+		printDriftReports(reports, opts)
+	}
+}
+
+// detectStackDrift runs drift detection on one stack and waits for the result,
+// or reuses the stored results when opts allow it. Errors are recorded in the
+// report rather than returned.
+func detectStackDrift(ctx context.Context, client *cloudformation.Client, stackName string, opts driftOptions) driftReport {
+	report := driftReport{Stack: stackName}
+
+	stack, err := describeStack(ctx, client, stackName)
+	if err != nil {
+		report.Error = fmt.Sprintf("failed to describe stack: %v", err)
+		return report
+	}
+	if opts.reuse(stack.DriftInformation, time.Now()) {
+		report.Cached = true
+		return report.withStoredResults(ctx, client, stack.DriftInformation)
+	}
+	if !opts.detect() {
+		report.DriftStatus = types.StackDriftStatusNotChecked
+		return report
+	}
+
+	initOut, err := client.DetectStackDrift(ctx, &cloudformation.DetectStackDriftInput{
+		StackName: &stackName,
+	})
+	if isDriftDetectionInProgress(err) {
+		stack, err = waitForRunningDetection(ctx, client, stackName, stack.DriftInformation, func() {})
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		return report.withStoredResults(ctx, client, stack.DriftInformation)
+	}
+	if err != nil {
+		report.Error = fmt.Sprintf("failed to initiate drift detection: %v", err)
+		return report
+	}
+
+	for {
+		time.Sleep(3 * time.Second)
+
+		status, err := client.DescribeStackDriftDetectionStatus(ctx, &cloudformation.DescribeStackDriftDetectionStatusInput{
+			StackDriftDetectionId: initOut.StackDriftDetectionId,
+		})
+		if err != nil {
+			report.Error = fmt.Sprintf("failed to get drift status: %v", err)
+			return report
+		}
+
+		switch status.DetectionStatus {
+		case types.StackDriftDetectionStatusDetectionComplete:
+			report.DriftStatus = status.StackDriftStatus
+			report.DriftedResources = int(aws.ToInt32(status.DriftedStackResourceCount))
+			report.LastChecked = status.Timestamp
+			if report.DriftedResources > 0 {
+				report.Resources, err = listDriftedResources(ctx, client, stackName)
+				if err != nil {
+					report.Error = fmt.Sprintf("failed to list drifted resources: %v", err)
+				}
+			}
+			return report
+		case types.StackDriftDetectionStatusDetectionFailed:
+			report.DriftStatus = status.StackDriftStatus
+			report.Error = "drift detection failed: " + getValue(status.DetectionStatusReason)
+			return report
+		}
+	}
+}
+
+// withStoredResults fills the report from the results of the last detection.
+func (r driftReport) withStoredResults(ctx context.Context, client *cloudformation.Client, info *types.StackDriftInformation) driftReport {
+	r.DriftStatus = info.StackDriftStatus
+	r.LastChecked = info.LastCheckTimestamp
+	resources, err := listDriftedResources(ctx, client, r.Stack)
+	if err != nil {
+		r.Error = fmt.Sprintf("failed to list drifted resources: %v", err)
+		return r
+	}
+	r.Resources = resources
+	r.DriftedResources = len(resources)
+	return r
+}
+
+// outcome is a one-line description of the report for progress output.
+func (r driftReport) outcome() string {
+	switch {
+	case r.Error != "":
+		return "error: " + r.Error
+	case r.DriftedResources > 0:
+		return fmt.Sprintf("%s (%d drifted resources)%s", r.DriftStatus, r.DriftedResources, r.cachedNote())
+	default:
+		return string(r.DriftStatus) + r.cachedNote()
+	}
+}
+
+func (r driftReport) cachedNote() string {
+	if !r.Cached || r.LastChecked == nil {
+		return ""
+	}
+	return ", cached from " + r.lastChecked()
+}
+
+func (r driftReport) lastChecked() string {
+	if r.LastChecked == nil {
+		return ""
+	}
+	return r.LastChecked.Format("2006-01-02 15:04:05")
+}
+
+func printDriftReports(reports []driftReport, opts driftOptions) {
+	depths := make([]int, len(reports))
+	for i, r := range reports {
+		depths[i] = r.depth
+	}
+	prefixes := treePrefixes(depths)
+	table := makeTable([]string{"STACK", "DRIFT STATUS", "DRIFTED RESOURCES", "LAST CHECKED", "ERROR"})
+	for i, r := range reports {
+		table.Rows = append(table.Rows, v1.TableRow{
+			Cells: []interface{}{
+				prefixes[i] + r.Stack,
+				string(r.DriftStatus),
+				r.DriftedResources,
+				r.lastChecked(),
+				r.Error,
+			},
+		})
+	}
+	mustPrint(table)
+
+	drifted, failed := countDriftReports(reports)
+	fmt.Printf("\n%d stack(s) checked: %d drifted, %d failed\n", len(reports), drifted, failed)
+
+	for _, r := range reports {
+		if len(r.Resources) == 0 {
+			continue
+		}
+		title := r.Stack
+		if r.Parent != "" {
+			title += " (" + r.LogicalID + " in " + r.Parent + ")"
+		}
+		fmt.Printf("\n=== %s: %d drifted resources ===\n\n", title, r.DriftedResources)
+		printDriftedResources(r.Resources, opts)
+	}
+}
+
+// countDriftReports counts the drifted stacks and the stacks whose detection
+// failed.
+func countDriftReports(reports []driftReport) (drifted, failed int) {
+	for _, r := range reports {
+		switch {
+		case r.Error != "":
+			failed++
+		case r.DriftStatus == types.StackDriftStatusDrifted:
+			drifted++
+		}
+	}
+	return drifted, failed
+}
+
+func writeDriftMarkdown(w io.Writer, reports []driftReport) {
+	drifted, failed := countDriftReports(reports)
+
+	fmt.Fprintf(w, "# Drift report\n\n")
+	fmt.Fprintf(w, "%d stack(s) checked: %d drifted, %d failed.\n\n", len(reports), drifted, failed)
+	fmt.Fprintf(w, "| Stack | Drift status | Drifted resources | Last checked | Error |\n")
+	fmt.Fprintf(w, "|---|---|---|---|---|\n")
+	for _, r := range reports {
+		fmt.Fprintf(w, "| %s%s | %s | %d | %s | %s |\n",
+			strings.Repeat("&nbsp;&nbsp;", r.depth), markdownCell(r.Stack), r.DriftStatus, r.DriftedResources, r.lastChecked(), markdownCell(r.Error))
+	}
+
+	for _, r := range reports {
+		if len(r.Resources) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n## %s\n", r.Stack)
+		for _, d := range r.Resources {
+			fmt.Fprintf(w, "\n### %s (%s): %s\n", getValue(d.LogicalResourceId), getValue(d.ResourceType), d.StackResourceDriftStatus)
+			if len(d.PropertyDifferences) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n| Property | Difference | Expected | Actual |\n")
+			fmt.Fprintf(w, "|---|---|---|---|\n")
+			for _, diff := range d.PropertyDifferences {
+				fmt.Fprintf(w, "| %s | %s | %s | %s |\n",
+					markdownCell(getValue(diff.PropertyPath)), diff.DifferenceType,
+					markdownCode(getValue(diff.ExpectedValue)), markdownCode(getValue(diff.ActualValue)))
+			}
+		}
+	}
+}
+
+// markdownCell escapes s for use inside a Markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// markdownCode formats s as inline code inside a Markdown table cell.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + strings.ReplaceAll(markdownCell(s), "`", "'") + "`"
+}
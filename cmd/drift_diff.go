@@ -0,0 +1,488 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// sideBySideWidth is the width of each column of the side-by-side model view.
+const sideBySideWidth = 60
+
+// diffLine is one line of a rendered property diff. Op is '-' for expected
+// only, '+' for actual only and ' ' for context.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+func (l diffLine) String() string {
+	return string(l.op) + " " + l.text
+}
+
+func printDiffLines(lines []diffLine, indent string) {
+	for _, l := range lines {
+		line := indent + l.String()
+		switch l.op {
+		case '-':
+			line = colorize(line, colorRed)
+		case '+':
+			line = colorize(line, colorGreen)
+		}
+		fmt.Println(line)
+	}
+}
+
+// absentValue marks a value missing on one side of a diff.
+type absentValue struct{}
+
+var absent any = absentValue{}
+
+func isAbsent(v any) bool {
+	_, ok := v.(absentValue)
+	return ok
+}
+
+// parsePropertyValue parses a drift ExpectedValue or ActualValue. Values are
+// JSON when structured and plain strings otherwise; an empty value is absent.
+func parsePropertyValue(raw string) any {
+	if raw == "" {
+		return absent
+	}
+	return decodePropertyJSON(raw)
+}
+
+// decodePropertyJSON decodes a drift value as JSON, keeping numbers exact, or
+// returns it unchanged if it is a plain string.
+func decodePropertyJSON(raw string) any {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return raw
+	}
+	return v
+}
+
+// normalizeValue returns a copy of v with arrays sorted by their canonical
+// JSON encoding, so that reordered lists (policy statements, security group
+// rules) compare equal. Object keys are always sorted when rendered. Values
+// are only normalized for comparison; the originals keep their order for
+// rendering and path lookups.
+func normalizeValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, child := range v {
+			m[k] = normalizeValue(child)
+		}
+		return m
+	case []any:
+		list := make([]any, len(v))
+		for i, child := range v {
+			list[i] = normalizeValue(child)
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			return canonicalJSON(list[i]) < canonicalJSON(list[j])
+		})
+		return list
+	default:
+		return v
+	}
+}
+
+// unorderedJSON is the canonical JSON encoding of v ignoring the order of
+// array elements.
+func unorderedJSON(v any) string {
+	return canonicalJSON(normalizeValue(v))
+}
+
+func canonicalJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func prettyJSON(v any) []string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return []string{fmt.Sprint(v)}
+	}
+	return strings.Split(string(b), "\n")
+}
+
+// diffPropertyValues renders a structural diff of two parsed property values.
+// Unchanged object members and array elements are collapsed into a count.
+func diffPropertyValues(expected, actual any) []diffLine {
+	var lines []diffLine
+	diffValue(&lines, "", expected, actual, "")
+	return lines
+}
+
+func diffValue(lines *[]diffLine, label string, expected, actual any, indent string) {
+	if isAbsent(expected) && isAbsent(actual) {
+		return
+	}
+	if !isAbsent(expected) && !isAbsent(actual) && canonicalJSON(expected) == canonicalJSON(actual) {
+		*lines = append(*lines, diffLine{' ', indent + label + "(unchanged)"})
+		return
+	}
+
+	expMap, expIsMap := expected.(map[string]any)
+	actMap, actIsMap := actual.(map[string]any)
+	if expIsMap && actIsMap {
+		*lines = append(*lines, diffLine{' ', indent + label + "{"})
+		diffObject(lines, expMap, actMap, indent+"  ")
+		*lines = append(*lines, diffLine{' ', indent + "}"})
+		return
+	}
+
+	expList, expIsList := expected.([]any)
+	actList, actIsList := actual.([]any)
+	if expIsList && actIsList {
+		*lines = append(*lines, diffLine{' ', indent + label + "["})
+		diffArray(lines, expList, actList, indent+"  ")
+		*lines = append(*lines, diffLine{' ', indent + "]"})
+		return
+	}
+
+	if !isAbsent(expected) {
+		appendValue(lines, '-', label, expected, indent)
+	}
+	if !isAbsent(actual) {
+		appendValue(lines, '+', label, actual, indent)
+	}
+}
+
+func diffObject(lines *[]diffLine, expected, actual map[string]any, indent string) {
+	keys := make(map[string]struct{})
+	for k := range expected {
+		keys[k] = struct{}{}
+	}
+	for k := range actual {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	unchanged := 0
+	flush := func() {
+		if unchanged > 0 {
+			*lines = append(*lines, diffLine{' ', indent + collapsed(unchanged)})
+			unchanged = 0
+		}
+	}
+	for _, k := range sorted {
+		exp, ok := expected[k]
+		if !ok {
+			exp = absent
+		}
+		act, ok := actual[k]
+		if !ok {
+			act = absent
+		}
+		if !isAbsent(exp) && !isAbsent(act) && canonicalJSON(exp) == canonicalJSON(act) {
+			unchanged++
+			continue
+		}
+		flush()
+		diffValue(lines, strconv.Quote(k)+": ", exp, act, indent)
+	}
+	flush()
+}
+
+// diffArray compares arrays as multisets: elements present on both sides are
+// unchanged, the rest are shown as removed or added. Changed objects are
+// paired up in order and diffed member by member. Arrays that differ only in
+// order are shown as reordered.
+func diffArray(lines *[]diffLine, expected, actual []any, indent string) {
+	remaining := make(map[string]int)
+	for _, v := range actual {
+		remaining[unorderedJSON(v)]++
+	}
+	unchanged := 0
+	var removed []any
+	for _, v := range expected {
+		key := unorderedJSON(v)
+		if remaining[key] > 0 {
+			remaining[key]--
+			unchanged++
+			continue
+		}
+		removed = append(removed, v)
+	}
+	var added []any
+	for _, v := range actual {
+		key := unorderedJSON(v)
+		if remaining[key] > 0 {
+			remaining[key]--
+			added = append(added, v)
+		}
+	}
+	if unchanged > 0 {
+		text := collapsed(unchanged)
+		if len(removed) == 0 && len(added) == 0 {
+			text += " (reordered)"
+		}
+		*lines = append(*lines, diffLine{' ', indent + text})
+	}
+
+	// Pair changed objects so that only their differing members are shown.
+	for len(removed) > 0 && len(added) > 0 {
+		_, expIsMap := removed[0].(map[string]any)
+		_, actIsMap := added[0].(map[string]any)
+		if !expIsMap || !actIsMap {
+			break
+		}
+		diffValue(lines, "", removed[0], added[0], indent)
+		removed, added = removed[1:], added[1:]
+	}
+	for _, v := range removed {
+		appendValue(lines, '-', "", v, indent)
+	}
+	for _, v := range added {
+		appendValue(lines, '+', "", v, indent)
+	}
+}
+
+func appendValue(lines *[]diffLine, op byte, label string, v any, indent string) {
+	for i, text := range prettyJSON(v) {
+		if i == 0 {
+			text = label + text
+		}
+		*lines = append(*lines, diffLine{op, indent + text})
+	}
+}
+
+func collapsed(n int) string {
+	if n == 1 {
+		return "... 1 unchanged"
+	}
+	return fmt.Sprintf("... %d unchanged", n)
+}
+
+// matchPropertyPath reports whether a drift property path such as
+// /PolicyDocument/Statement/0 is selected by the --property-path filters. A
+// filter selects its own path and everything below it, or is matched as a
+// glob when it contains wildcards.
+func matchPropertyPath(propertyPath string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		f = "/" + strings.Trim(f, "/")
+		if strings.ContainsAny(f, "*?[") {
+			if ok, _ := path.Match(f, propertyPath); ok {
+				return true
+			}
+			continue
+		}
+		if propertyPath == f || strings.HasPrefix(propertyPath, f+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDriftedResources narrows the property differences of each resource to
+// the --property-path filters and drops resources left without any.
+func filterDriftedResources(drifted []types.StackResourceDrift, filters []string) []types.StackResourceDrift {
+	if len(filters) == 0 {
+		return drifted
+	}
+	var filtered []types.StackResourceDrift
+	for _, d := range drifted {
+		var diffs []types.PropertyDifference
+		for _, diff := range d.PropertyDifferences {
+			if matchPropertyPath(getValue(diff.PropertyPath), filters) {
+				diffs = append(diffs, diff)
+			}
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		d.PropertyDifferences = diffs
+		filtered = append(filtered, d)
+	}
+	return filtered
+}
+
+// lookupProperty resolves a JSON pointer style property path in a parsed
+// resource model.
+func lookupProperty(v any, pointer string) (any, bool) {
+	for _, part := range strings.Split(strings.Trim(pointer, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// sideBySide renders the expected and actual resource models in two columns,
+// aligned on their common lines. The marker between the columns is '|' for
+// changed lines, '<' for expected only and '>' for actual only.
+func sideBySide(expected, actual []string, width int) []diffLine {
+	ops := lineDiff(expected, actual)
+
+	var rows []diffLine
+	row := func(op byte, left, right string, marker string) {
+		rows = append(rows, diffLine{op, fmt.Sprintf("%-*s %s %s", width, truncate(left, width), marker, right)})
+	}
+
+	var removed, added []string
+	flush := func() {
+		n := max(len(removed), len(added))
+		for i := 0; i < n; i++ {
+			switch {
+			case i < len(removed) && i < len(added):
+				row('~', removed[i], added[i], "|")
+			case i < len(removed):
+				row('-', removed[i], "", "<")
+			default:
+				row('+', "", added[i], ">")
+			}
+		}
+		removed, added = nil, nil
+	}
+	for _, op := range ops {
+		switch op.op {
+		case '-':
+			removed = append(removed, op.text)
+		case '+':
+			added = append(added, op.text)
+		default:
+			flush()
+			row(' ', op.text, op.text, " ")
+		}
+	}
+	flush()
+	return rows
+}
+
+// lineDiff computes a line-level edit script from a to b using the longest
+// common subsequence.
+func lineDiff(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffLine{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffLine{'+', b[j]})
+	}
+	return ops
+}
+
+// printResourceModels prints the full expected and actual models of a
+// drifted resource side by side, or only the subtrees selected by plain
+// --property-path filters.
+func printResourceModels(d types.StackResourceDrift, filters []string) {
+	expected := parsePropertyValue(getValue(d.ExpectedProperties))
+	actual := parsePropertyValue(getValue(d.ActualProperties))
+
+	type section struct {
+		title            string
+		expected, actual any
+	}
+	sections := []section{{"", expected, actual}}
+	if len(filters) > 0 {
+		sections = nil
+		for _, f := range filters {
+			if strings.ContainsAny(f, "*?[") {
+				continue
+			}
+			exp, ok := lookupProperty(expected, f)
+			if !ok {
+				exp = absent
+			}
+			act, ok := lookupProperty(actual, f)
+			if !ok {
+				act = absent
+			}
+			sections = append(sections, section{"/" + strings.Trim(f, "/"), exp, act})
+		}
+		if len(sections) == 0 {
+			sections = []section{{"", expected, actual}}
+		}
+	}
+
+	fmt.Printf("  %-*s   %s\n", sideBySideWidth, "EXPECTED", "ACTUAL")
+	for _, s := range sections {
+		if s.title != "" {
+			fmt.Printf("  %s:\n", s.title)
+		}
+		for _, r := range sideBySide(modelLines(s.expected), modelLines(s.actual), sideBySideWidth) {
+			line := "  " + r.text
+			switch r.op {
+			case '-':
+				line = colorize(line, colorRed)
+			case '+':
+				line = colorize(line, colorGreen)
+			case '~':
+				line = colorize(line, colorYellow)
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+func modelLines(v any) []string {
+	if isAbsent(v) {
+		return nil
+	}
+	return prettyJSON(v)
+}
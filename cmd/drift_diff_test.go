@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func renderDiff(lines []diffLine) string {
+	var out []string
+	for _, l := range lines {
+		out = append(out, l.String())
+	}
+	return strings.Join(out, "\n")
+}
+
+func TestDiffPropertyValues_Policy(t *testing.T) {
+	expected := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"},{"Effect":"Allow","Action":"sqs:SendMessage","Resource":"*"}]}`
+	// Same statements in a different order, one action widened.
+	actual := `{"Statement":[{"Effect":"Allow","Action":"sqs:SendMessage","Resource":"*"},{"Effect":"Allow","Action":"s3:*","Resource":"*"}],"Version":"2012-10-17"}`
+
+	got := renderDiff(diffPropertyValues(parsePropertyValue(expected), parsePropertyValue(actual)))
+
+	for _, want := range []string{
+		`    "Statement": [`,
+		`      ... 1 unchanged`,
+		`-       "Action": "s3:GetObject"`,
+		`+       "Action": "s3:*"`,
+		`        ... 2 unchanged`,
+		`    ... 1 unchanged`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "sqs:SendMessage") {
+		t.Errorf("unchanged statement should be collapsed:\n%s", got)
+	}
+}
+
+func TestDiffPropertyValues_Scalars(t *testing.T) {
+	got := renderDiff(diffPropertyValues(parsePropertyValue("Enabled"), parsePropertyValue("Suspended")))
+	if want := "- \"Enabled\"\n+ \"Suspended\""; got != want {
+		t.Errorf("diff = %q, want %q", got, want)
+	}
+
+	got = renderDiff(diffPropertyValues(parsePropertyValue(""), parsePropertyValue("30")))
+	if want := "+ 30"; got != want {
+		t.Errorf("added value diff = %q, want %q", got, want)
+	}
+
+	got = renderDiff(diffPropertyValues(parsePropertyValue(`["b","a"]`), parsePropertyValue(`["a","b"]`)))
+	if want := "  [\n    ... 2 unchanged (reordered)\n  ]"; got != want {
+		t.Errorf("reordered list diff = %q, want %q", got, want)
+	}
+}
+
+func TestParsePropertyValueKeepsOrder(t *testing.T) {
+	raw := `{"Tags":[{"Key":"b","Value":"2"},{"Key":"a","Value":"1"}]}`
+	model := parsePropertyValue(raw)
+	if v, ok := lookupProperty(model, "/Tags/0/Key"); !ok || v != "b" {
+		t.Errorf("lookup Tags/0/Key = %v, %v; want the original first tag", v, ok)
+	}
+	unorderedJSON(model)
+	if got := canonicalJSON(model); got != raw {
+		t.Errorf("comparison modified the value: %s", got)
+	}
+
+	// Nested reordering inside a changed object is still collapsed.
+	got := renderDiff(diffPropertyValues(
+		parsePropertyValue(`{"Ports":[80,443],"Name":"a"}`),
+		parsePropertyValue(`{"Ports":[443,80],"Name":"b"}`)))
+	for _, want := range []string{`-   "Name": "a"`, `+   "Name": "b"`, `... 2 unchanged (reordered)`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestMatchPropertyPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		filters []string
+		want    bool
+	}{
+		{"/PolicyDocument/Statement/0", nil, true},
+		{"/PolicyDocument/Statement/0", []string{"/PolicyDocument"}, true},
+		{"/PolicyDocument/Statement/0", []string{"PolicyDocument/Statement/"}, true},
+		{"/PolicyDocumentName", []string{"/PolicyDocument"}, false},
+		{"/SecurityGroupIngress/2", []string{"/SecurityGroup*/*"}, true},
+		{"/Tags/0", []string{"/PolicyDocument", "/Tags"}, true},
+	}
+	for _, tt := range tests {
+		if got := matchPropertyPath(tt.path, tt.filters); got != tt.want {
+			t.Errorf("matchPropertyPath(%q, %v) = %v, want %v", tt.path, tt.filters, got, tt.want)
+		}
+	}
+}
+
+func TestFilterDriftedResources(t *testing.T) {
+	drifted := []types.StackResourceDrift{
+		{
+			LogicalResourceId: strPtr("Role"),
+			PropertyDifferences: []types.PropertyDifference{
+				{PropertyPath: strPtr("/Policies/0/PolicyDocument")},
+				{PropertyPath: strPtr("/Tags/0")},
+			},
+		},
+		{
+			LogicalResourceId:   strPtr("Bucket"),
+			PropertyDifferences: []types.PropertyDifference{{PropertyPath: strPtr("/Tags/1")}},
+		},
+	}
+
+	filtered := filterDriftedResources(drifted, []string{"/Policies"})
+	if len(filtered) != 1 || getValue(filtered[0].LogicalResourceId) != "Role" || len(filtered[0].PropertyDifferences) != 1 {
+		t.Fatalf("unexpected filtered resources %+v", filtered)
+	}
+	if len(drifted[0].PropertyDifferences) != 2 {
+		t.Error("filtering must not modify the input")
+	}
+}
+
+func TestLookupProperty(t *testing.T) {
+	model := parsePropertyValue(`{"VersioningConfiguration":{"Status":"Enabled"},"Tags":[{"Key":"a","Value":"1"}]}`)
+	if v, ok := lookupProperty(model, "/VersioningConfiguration/Status"); !ok || v != "Enabled" {
+		t.Errorf("lookup Status = %v, %v", v, ok)
+	}
+	if v, ok := lookupProperty(model, "/Tags/0/Key"); !ok || v != "a" {
+		t.Errorf("lookup Tags/0/Key = %v, %v", v, ok)
+	}
+	if _, ok := lookupProperty(model, "/Tags/3"); ok {
+		t.Error("expected out-of-range index to fail")
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	expected := []string{"{", `  "A": 1,`, `  "B": 2,`, `  "C": 3`, "}"}
+	actual := []string{"{", `  "A": 1,`, `  "B": 5,`, `  "C": 3,`, `  "D": 4`, "}"}
+
+	rows := sideBySide(expected, actual, 12)
+	var ops []byte
+	for _, r := range rows {
+		ops = append(ops, r.op)
+	}
+	if got, want := string(ops), "  ~~+ "; got != want {
+		t.Fatalf("row ops = %q, want %q\n%s", got, want, renderDiff(rows))
+	}
+	if want := `  "B": 2,    |   "B": 5,`; rows[2].text != want {
+		t.Errorf("changed row = %q, want %q", rows[2].text, want)
+	}
+}
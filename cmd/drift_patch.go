@@ -0,0 +1,526 @@
+package cmd
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"gopkg.in/yaml.v3"
+)
+
+// patchOp is one RFC 6902 JSON patch operation.
+type patchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// MarshalJSON writes the value of add and replace operations even when it is
+// null, false, 0 or "", and leaves it out of remove operations.
+func (op patchOp) MarshalJSON() ([]byte, error) {
+	type plain patchOp
+	if op.Op == "add" || op.Op == "replace" {
+		return json.Marshal(plain(op))
+	}
+	return json.Marshal(struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}{op.Op, op.Path})
+}
+
+// patchSkip is a drifted property that was left out of the patch.
+type patchSkip struct {
+	Path   string
+	Reason string
+}
+
+// emitDriftPatch writes a template patch adopting the actual values of the
+// drifted resources in report, as configured by opts.
+func emitDriftPatch(ctx context.Context, client *cloudformation.Client, report driftReport, opts driftOptions) {
+	out, err := client.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &report.Stack,
+		TemplateStage: types.TemplateStageOriginal,
+	})
+	if err != nil {
+		fatalf("failed to get template for stack %q: %v\n", report.Stack, err)
+	}
+	body := getValue(out.TemplateBody)
+
+	doc, err := parseTemplateNode(body)
+	if err != nil {
+		fatalf("failed to parse template for stack %q: %v\n", report.Stack, err)
+	}
+
+	ops, skipped := planDriftPatch(doc, filterDriftedResources(report.Resources, opts.properties))
+
+	var buf bytes.Buffer
+	if opts.patchFormat == "json-patch" {
+		if ops == nil {
+			ops = []patchOp{}
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(ops)
+	} else {
+		if err = applyPatch(doc, ops); err == nil {
+			err = encodeTemplateNode(&buf, doc, isJSONTemplate(body))
+		}
+	}
+	if err != nil {
+		fatalf("failed to write patch: %v\n", err)
+	}
+
+	if opts.emitPatch == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = os.WriteFile(opts.emitPatch, buf.Bytes(), 0o644)
+	}
+	if err != nil {
+		fatalf("failed to write %s: %v\n", opts.emitPatch, err)
+	}
+
+	if opts.emitPatch != "-" {
+		fmt.Fprintf(os.Stderr, "Wrote %d change(s) to %s\n", len(ops), opts.emitPatch)
+	}
+	for _, s := range skipped {
+		fmt.Fprint(os.Stderr, colorize(fmt.Sprintf("warning: skipped %s: %s\n", s.Path, s.Reason), colorYellow))
+	}
+}
+
+// planDriftPatch maps the property differences of the drifted resources onto
+// the template and returns the operations that make the template match the
+// actual resource configuration. Differences under intrinsic functions, or
+// that cannot be located in the template, are returned as skipped instead.
+func planDriftPatch(doc *yaml.Node, drifted []types.StackResourceDrift) ([]patchOp, []patchSkip) {
+	var ops []patchOp
+	var skipped []patchSkip
+
+	resources := mappingValue(templateRoot(doc), "Resources")
+	for _, d := range drifted {
+		logicalID := getValue(d.LogicalResourceId)
+		base := "/Resources/" + escapePointer(logicalID) + "/Properties"
+
+		if d.StackResourceDriftStatus == types.StackResourceDriftStatusDeleted {
+			skipped = append(skipped, patchSkip{"/Resources/" + escapePointer(logicalID),
+				"resource was deleted outside CloudFormation; remove or re-create it manually"})
+			continue
+		}
+		resource := mappingValue(resources, logicalID)
+		if resource == nil {
+			skipped = append(skipped, patchSkip{"/Resources/" + escapePointer(logicalID),
+				"resource not found in the template (created by a transform?)"})
+			continue
+		}
+		properties := mappingValue(resource, "Properties")
+
+		var resourceOps []patchOp
+		for _, diff := range d.PropertyDifferences {
+			ptr := base + getValue(diff.PropertyPath)
+			op, reason := planPropertyPatch(properties, diff)
+			if reason != "" {
+				skipped = append(skipped, patchSkip{ptr, reason})
+				continue
+			}
+			op.Path = ptr
+			resourceOps = append(resourceOps, op)
+		}
+		ops = append(ops, orderArrayOps(resourceOps)...)
+	}
+	return ops, skipped
+}
+
+// orderArrayOps orders the operations of a resource so that they apply in
+// sequence. Drift reports array removals at their index in the template and
+// additions at their index in the actual list, but each removal or addition
+// shifts the elements after it: other changes go first, then removals from
+// the highest index down, then additions from the lowest index up.
+func orderArrayOps(ops []patchOp) []patchOp {
+	var other, removes, adds []patchOp
+	for _, op := range ops {
+		switch {
+		case !isArrayElementPath(op.Path):
+			other = append(other, op)
+		case op.Op == "remove":
+			removes = append(removes, op)
+		case op.Op == "add":
+			adds = append(adds, op)
+		default:
+			other = append(other, op)
+		}
+	}
+	slices.SortStableFunc(removes, func(a, b patchOp) int { return comparePointers(b.Path, a.Path) })
+	slices.SortStableFunc(adds, func(a, b patchOp) int { return comparePointers(a.Path, b.Path) })
+	return slices.Concat(other, removes, adds)
+}
+
+// isArrayElementPath reports whether a JSON pointer ends with an array index.
+func isArrayElementPath(ptr string) bool {
+	parts := splitPointer(ptr)
+	if len(parts) == 0 {
+		return false
+	}
+	last := parts[len(parts)-1]
+	if last == "-" {
+		return true
+	}
+	_, err := strconv.Atoi(last)
+	return err == nil
+}
+
+// comparePointers orders JSON pointers segment by segment, comparing array
+// indexes numerically.
+func comparePointers(a, b string) int {
+	pa, pb := splitPointer(a), splitPointer(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] == pb[i] {
+			continue
+		}
+		ia, errA := strconv.Atoi(pa[i])
+		ib, errB := strconv.Atoi(pb[i])
+		if errA == nil && errB == nil {
+			return cmp.Compare(ia, ib)
+		}
+		return strings.Compare(pa[i], pb[i])
+	}
+	return cmp.Compare(len(pa), len(pb))
+}
+
+func planPropertyPatch(properties *yaml.Node, diff types.PropertyDifference) (patchOp, string) {
+	if properties == nil {
+		return patchOp{}, "resource has no Properties in the template"
+	}
+	parts := splitPointer(getValue(diff.PropertyPath))
+	if len(parts) == 0 {
+		return patchOp{}, "empty property path"
+	}
+
+	node := properties
+	for i, part := range parts {
+		if fn := intrinsicName(node); fn != "" {
+			return patchOp{}, "value is computed by " + fn + "; update the template by hand"
+		}
+		child := nodeChild(node, part)
+		if child == nil {
+			if i == len(parts)-1 && diff.DifferenceType == types.DifferenceTypeAdd {
+				node = nil
+				break
+			}
+			return patchOp{}, "property not found in the template"
+		}
+		node = child
+	}
+	if fn := intrinsicName(node); fn != "" {
+		return patchOp{}, "value is computed by " + fn + "; update the template by hand"
+	}
+
+	switch diff.DifferenceType {
+	case types.DifferenceTypeRemove:
+		return patchOp{Op: "remove"}, ""
+	case types.DifferenceTypeAdd:
+		return patchOp{Op: "add", Value: patchValue(getValue(diff.ActualValue), node)}, ""
+	default:
+		return patchOp{Op: "replace", Value: patchValue(getValue(diff.ActualValue), node)}, ""
+	}
+}
+
+// patchValue parses a drift ActualValue for use in the patch. Scalars stay
+// strings when the template has a string there, so "8080" is not turned into
+// a number.
+func patchValue(raw string, current *yaml.Node) any {
+	v := decodePropertyJSON(raw)
+	if current != nil && current.Kind == yaml.ScalarNode && current.Tag == "!!str" {
+		switch v.(type) {
+		case map[string]any, []any:
+		default:
+			return raw
+		}
+	}
+	return plainNumbers(v)
+}
+
+// plainNumbers replaces json.Number values with int64 or float64 so they
+// encode as numbers in YAML.
+func plainNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			v[k] = plainNumbers(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = plainNumbers(child)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// applyPatch applies JSON patch operations to a template node tree.
+func applyPatch(doc *yaml.Node, ops []patchOp) error {
+	root := templateRoot(doc)
+	for _, op := range ops {
+		parts := splitPointer(op.Path)
+		if len(parts) == 0 {
+			return fmt.Errorf("invalid patch path %q", op.Path)
+		}
+		parent := root
+		for _, part := range parts[:len(parts)-1] {
+			if parent = nodeChild(parent, part); parent == nil {
+				return fmt.Errorf("patch path %q not found", op.Path)
+			}
+		}
+		var value *yaml.Node
+		if op.Op != "remove" {
+			value = &yaml.Node{}
+			if err := value.Encode(op.Value); err != nil {
+				return fmt.Errorf("failed to encode value for %q: %w", op.Path, err)
+			}
+		}
+		if err := setNodeChild(parent, parts[len(parts)-1], op.Op, value); err != nil {
+			return fmt.Errorf("%s %s: %w", op.Op, op.Path, err)
+		}
+	}
+	return nil
+}
+
+func setNodeChild(parent *yaml.Node, key, op string, value *yaml.Node) error {
+	switch parent.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value != key {
+				continue
+			}
+			if op == "remove" {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+			} else {
+				parent.Content[i+1] = value
+			}
+			return nil
+		}
+		if op != "add" {
+			return fmt.Errorf("key %q not found", key)
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		return nil
+	case yaml.SequenceNode:
+		if key == "-" && op == "add" {
+			parent.Content = append(parent.Content, value)
+			return nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i > len(parent.Content) || (op != "add" && i == len(parent.Content)) {
+			return fmt.Errorf("index %q out of range", key)
+		}
+		switch op {
+		case "remove":
+			parent.Content = append(parent.Content[:i], parent.Content[i+1:]...)
+		case "add":
+			parent.Content = append(parent.Content[:i], append([]*yaml.Node{value}, parent.Content[i:]...)...)
+		default:
+			parent.Content[i] = value
+		}
+		return nil
+	default:
+		return fmt.Errorf("cannot set %q on a scalar", key)
+	}
+}
+
+// parseTemplateNode parses a JSON or YAML template into a node tree, keeping
+// key order, comments and short-form intrinsic function tags such as !Ref.
+func parseTemplateNode(body string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, err
+	}
+	if root := templateRoot(&doc); root == nil || root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("template is not a mapping")
+	}
+	return &doc, nil
+}
+
+func templateRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		return doc.Content[0]
+	}
+	return doc
+}
+
+func isJSONTemplate(body string) bool {
+	return strings.HasPrefix(strings.TrimSpace(body), "{")
+}
+
+// encodeTemplateNode writes a template node tree as JSON or YAML, keeping the
+// original key order.
+func encodeTemplateNode(w io.Writer, doc *yaml.Node, asJSON bool) error {
+	if !asJSON {
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	v, err := nodeJSONValue(templateRoot(doc))
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// orderedObject is a JSON object that keeps its key order when encoded.
+type orderedObject struct {
+	keys   []string
+	values []any
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func nodeJSONValue(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return nodeJSONValue(n.Alias)
+	case yaml.MappingNode:
+		obj := orderedObject{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := nodeJSONValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, n.Content[i].Value)
+			obj.values = append(obj.values, v)
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		list := []any{}
+		for _, c := range n.Content {
+			v, err := nodeJSONValue(c)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	default:
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// nodeChild returns the child of a mapping or sequence node addressed by one
+// JSON pointer segment, or nil.
+func nodeChild(n *yaml.Node, part string) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		return mappingValue(n, part)
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i >= len(n.Content) {
+			return nil
+		}
+		return n.Content[i]
+	}
+	return nil
+}
+
+// intrinsicName returns the intrinsic function a template node is computed
+// by, in either the short (!Sub) or the long (Fn::Sub) form, or "".
+func intrinsicName(n *yaml.Node) string {
+	if n == nil {
+		return ""
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if strings.HasPrefix(n.Tag, "!") && !strings.HasPrefix(n.Tag, "!!") {
+		return n.Tag
+	}
+	if n.Kind == yaml.MappingNode && len(n.Content) == 2 {
+		key := n.Content[0].Value
+		if key == "Ref" || strings.HasPrefix(key, "Fn::") {
+			return key
+		}
+	}
+	return ""
+}
+
+func splitPointer(ptr string) []string {
+	ptr = strings.TrimPrefix(ptr, "/")
+	if ptr == "" {
+		return nil
+	}
+	parts := strings.Split(ptr, "/")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(strings.ReplaceAll(p, "~1", "/"), "~0", "~")
+	}
+	return parts
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+const patchTemplateYAML = `AWSTemplateFormatVersion: "2010-09-09"
+Resources:
+  # Application bucket
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: !Sub "${AWS::StackName}-data"
+      VersioningConfiguration:
+        Status: Enabled
+      Tags:
+        - Key: team
+          Value: platform
+  Service:
+    Type: AWS::ECS::Service
+    Properties:
+      DesiredCount: 2
+      HealthCheckGracePeriodSeconds: "60"
+      Cluster:
+        Ref: Cluster
+`
+
+func propertyDiff(path string, kind types.DifferenceType, expected, actual string) types.PropertyDifference {
+	return types.PropertyDifference{
+		PropertyPath:   strPtr(path),
+		DifferenceType: kind,
+		ExpectedValue:  strPtr(expected),
+		ActualValue:    strPtr(actual),
+	}
+}
+
+func patchDrifts() []types.StackResourceDrift {
+	return []types.StackResourceDrift{
+		{
+			LogicalResourceId:        strPtr("Bucket"),
+			StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+			PropertyDifferences: []types.PropertyDifference{
+				propertyDiff("/VersioningConfiguration/Status", types.DifferenceTypeNotEqual, "Enabled", "Suspended"),
+				propertyDiff("/BucketName", types.DifferenceTypeNotEqual, "app-data", "other"),
+				propertyDiff("/Tags/1", types.DifferenceTypeAdd, "", `{"Key":"owner","Value":"alice"}`),
+			},
+		},
+		{
+			LogicalResourceId:        strPtr("Service"),
+			StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+			PropertyDifferences: []types.PropertyDifference{
+				propertyDiff("/DesiredCount", types.DifferenceTypeNotEqual, "2", "4"),
+				propertyDiff("/HealthCheckGracePeriodSeconds", types.DifferenceTypeNotEqual, "60", "120"),
+				propertyDiff("/Cluster", types.DifferenceTypeNotEqual, "prod", "staging"),
+			},
+		},
+		{
+			LogicalResourceId:        strPtr("Queue"),
+			StackResourceDriftStatus: types.StackResourceDriftStatusDeleted,
+		},
+	}
+}
+
+func TestPlanDriftPatch(t *testing.T) {
+	doc, err := parseTemplateNode(patchTemplateYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, skipped := planDriftPatch(doc, patchDrifts())
+
+	var gotOps []string
+	for _, op := range ops {
+		gotOps = append(gotOps, op.Op+" "+op.Path)
+	}
+	wantOps := []string{
+		"replace /Resources/Bucket/Properties/VersioningConfiguration/Status",
+		"add /Resources/Bucket/Properties/Tags/1",
+		"replace /Resources/Service/Properties/DesiredCount",
+		"replace /Resources/Service/Properties/HealthCheckGracePeriodSeconds",
+	}
+	if strings.Join(gotOps, "\n") != strings.Join(wantOps, "\n") {
+		t.Errorf("ops =\n%s\nwant\n%s", strings.Join(gotOps, "\n"), strings.Join(wantOps, "\n"))
+	}
+	if ops[2].Value != int64(4) {
+		t.Errorf("DesiredCount value = %#v, want int64(4)", ops[2].Value)
+	}
+	if ops[3].Value != "120" {
+		t.Errorf("string property value = %#v, want \"120\"", ops[3].Value)
+	}
+
+	var gotSkipped []string
+	for _, s := range skipped {
+		gotSkipped = append(gotSkipped, s.Path+": "+s.Reason)
+	}
+	joined := strings.Join(gotSkipped, "\n")
+	for _, want := range []string{
+		"/Resources/Bucket/Properties/BucketName: value is computed by !Sub",
+		"/Resources/Service/Properties/Cluster: value is computed by Ref",
+		"/Resources/Queue: resource was deleted outside CloudFormation",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("skipped missing %q:\n%s", want, joined)
+		}
+	}
+}
+
+func TestApplyPatchYAML(t *testing.T) {
+	doc, err := parseTemplateNode(patchTemplateYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, _ := planDriftPatch(doc, patchDrifts())
+	if err := applyPatch(doc, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeTemplateNode(&buf, doc, false); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# Application bucket",
+		`BucketName: !Sub "${AWS::StackName}-data"`,
+		"Status: Suspended",
+		"- Key: owner",
+		"DesiredCount: 4",
+		`HealthCheckGracePeriodSeconds: "120"`,
+		"Ref: Cluster",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("patched template missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestApplyPatchJSONKeepsOrder(t *testing.T) {
+	body := `{"Resources": {"Topic": {"Type": "AWS::SNS::Topic", "Properties": {"TopicName": "alerts", "DisplayName": "Alerts"}}}}`
+	doc, err := parseTemplateNode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drifted := []types.StackResourceDrift{{
+		LogicalResourceId:        strPtr("Topic"),
+		StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+		PropertyDifferences: []types.PropertyDifference{
+			propertyDiff("/DisplayName", types.DifferenceTypeRemove, "Alerts", ""),
+			propertyDiff("/KmsMasterKeyId", types.DifferenceTypeAdd, "", "alias/aws/sns"),
+		},
+	}}
+	ops, skipped := planDriftPatch(doc, drifted)
+	if len(skipped) != 0 {
+		t.Fatalf("unexpected skipped %+v", skipped)
+	}
+	if err := applyPatch(doc, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeTemplateNode(&buf, doc, isJSONTemplate(body)); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "Resources": {
+    "Topic": {
+      "Type": "AWS::SNS::Topic",
+      "Properties": {
+        "TopicName": "alerts",
+        "KmsMasterKeyId": "alias/aws/sns"
+      }
+    }
+  }
+}
+`
+	if buf.String() != want {
+		t.Errorf("patched JSON =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestDriftPatchArrayIndexes(t *testing.T) {
+	body := `Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      Tags:
+        - Key: a
+          Value: "1"
+        - Key: b
+          Value: "2"
+        - Key: c
+          Value: "3"
+`
+	// The template has tags a, b, c; the bucket has a, x, y.
+	drifted := []types.StackResourceDrift{{
+		LogicalResourceId:        strPtr("Bucket"),
+		StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+		PropertyDifferences: []types.PropertyDifference{
+			propertyDiff("/Tags/1", types.DifferenceTypeRemove, `{"Key":"b","Value":"2"}`, ""),
+			propertyDiff("/Tags/2", types.DifferenceTypeRemove, `{"Key":"c","Value":"3"}`, ""),
+			propertyDiff("/Tags/2", types.DifferenceTypeAdd, "", `{"Key":"y","Value":"5"}`),
+			propertyDiff("/Tags/1", types.DifferenceTypeAdd, "", `{"Key":"x","Value":"4"}`),
+		},
+	}}
+	doc, err := parseTemplateNode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, skipped := planDriftPatch(doc, drifted)
+	if len(skipped) != 0 {
+		t.Fatalf("unexpected skipped %+v", skipped)
+	}
+
+	var gotOps []string
+	for _, op := range ops {
+		gotOps = append(gotOps, op.Op+" "+op.Path)
+	}
+	wantOps := []string{
+		"remove /Resources/Bucket/Properties/Tags/2",
+		"remove /Resources/Bucket/Properties/Tags/1",
+		"add /Resources/Bucket/Properties/Tags/1",
+		"add /Resources/Bucket/Properties/Tags/2",
+	}
+	if strings.Join(gotOps, "\n") != strings.Join(wantOps, "\n") {
+		t.Errorf("ops =\n%s\nwant\n%s", strings.Join(gotOps, "\n"), strings.Join(wantOps, "\n"))
+	}
+
+	if err := applyPatch(doc, ops); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := encodeTemplateNode(&buf, doc, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(buf.String()), ""); !strings.Contains(got,
+		`"Tags":[{"Key":"a","Value":"1"},{"Key":"x","Value":"4"},{"Key":"y","Value":"5"}]`) {
+		t.Errorf("patched tags =\n%s", buf.String())
+	}
+}
+
+func TestDriftPatchFalsyValues(t *testing.T) {
+	body := `Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      FifoQueue: true
+      DelaySeconds: 5
+      RedrivePolicy:
+        maxReceiveCount: 3
+`
+	drifted := []types.StackResourceDrift{{
+		LogicalResourceId:        strPtr("Queue"),
+		StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+		PropertyDifferences: []types.PropertyDifference{
+			propertyDiff("/FifoQueue", types.DifferenceTypeNotEqual, "true", "false"),
+			propertyDiff("/DelaySeconds", types.DifferenceTypeNotEqual, "5", "0"),
+			propertyDiff("/KmsMasterKeyId", types.DifferenceTypeAdd, "", "null"),
+			propertyDiff("/RedrivePolicy", types.DifferenceTypeRemove, `{"maxReceiveCount":3}`, ""),
+		},
+	}}
+	doc, err := parseTemplateNode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, _ := planDriftPatch(doc, drifted)
+	b, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"op":"replace","path":"/Resources/Queue/Properties/FifoQueue","value":false},` +
+		`{"op":"replace","path":"/Resources/Queue/Properties/DelaySeconds","value":0},` +
+		`{"op":"add","path":"/Resources/Queue/Properties/KmsMasterKeyId","value":null},` +
+		`{"op":"remove","path":"/Resources/Queue/Properties/RedrivePolicy"}]`
+	if string(b) != want {
+		t.Errorf("json patch =\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestComparePointers(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"/Tags/2", "/Tags/10", -1},
+		{"/Tags/1", "/Tags/1", 0},
+		{"/Rules/1/Ports/0", "/Rules/0", 1},
+		{"/A", "/A/0", -1},
+	}
+	for _, tt := range tests {
+		if got := comparePointers(tt.a, tt.b); got != tt.want {
+			t.Errorf("comparePointers(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIntrinsicName(t *testing.T) {
+	doc, err := parseTemplateNode("A: !GetAtt Role.Arn\nB: {\"Fn::Join\": [\"\", []]}\nC: plain\nD: {Key: a, Value: b}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := templateRoot(doc)
+	tests := map[string]string{"A": "!GetAtt", "B": "Fn::Join", "C": "", "D": ""}
+	for key, want := range tests {
+		if got := intrinsicName(mappingValue(root, key)); got != want {
+			t.Errorf("intrinsicName(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestSplitPointer(t *testing.T) {
+	got := splitPointer("/Tags/0/a~1b~0c")
+	if strings.Join(got, "|") != "Tags|0|a/b~c" {
+		t.Errorf("splitPointer = %q", got)
+	}
+	if escapePointer("a/b~c") != "a~1b~0c" {
+		t.Errorf("escapePointer = %q", escapePointer("a/b~c"))
+	}
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestWriteDriftMarkdown(t *testing.T) {
+	checked := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	reports := []driftReport{
+		{
+			Stack:            "app",
+			DriftStatus:      types.StackDriftStatusDrifted,
+			DriftedResources: 1,
+			Resources: []types.StackResourceDrift{{
+				LogicalResourceId:        strPtr("Bucket"),
+				ResourceType:             strPtr("AWS::S3::Bucket"),
+				StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+				PropertyDifferences: []types.PropertyDifference{{
+					PropertyPath:   strPtr("/VersioningConfiguration/Status"),
+					DifferenceType: types.DifferenceTypeNotEqual,
+					ExpectedValue:  strPtr("Enabled"),
+					ActualValue:    strPtr("Suspended"),
+				}},
+			}},
+		},
+		{Stack: "db", DriftStatus: types.StackDriftStatusInSync, LastChecked: &checked},
+		{Stack: "legacy", Error: "stack is in a state | that cannot be checked"},
+	}
+
+	var buf bytes.Buffer
+	writeDriftMarkdown(&buf, reports)
+	out := buf.String()
+
+	for _, want := range []string{
+		"3 stack(s) checked: 1 drifted, 1 failed.",
+		"| app | DRIFTED | 1 |  |  |",
+		"| db | IN_SYNC | 0 | 2024-01-01 10:00:00 |  |",
+		`| legacy |  | 0 |  | stack is in a state \| that cannot be checked |`,
+		"## app",
+		"### Bucket (AWS::S3::Bucket): MODIFIED",
+		"| /VersioningConfiguration/Status | NOT_EQUAL | `Enabled` | `Suspended` |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown report missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "## db") {
+		t.Error("stacks without drifted resources should not get a details section")
+	}
+}
+
+func TestDriftReportOutcome(t *testing.T) {
+	tests := []struct {
+		report driftReport
+		want   string
+	}{
+		{driftReport{DriftStatus: types.StackDriftStatusInSync}, "IN_SYNC"},
+		{driftReport{DriftStatus: types.StackDriftStatusDrifted, DriftedResources: 2}, "DRIFTED (2 drifted resources)"},
+		{driftReport{Error: "throttled"}, "error: throttled"},
+	}
+	for _, tt := range tests {
+		if got := tt.report.outcome(); got != tt.want {
+			t.Errorf("outcome() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestDriftOptionsReuse(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	checked := now.Add(-2 * time.Hour)
+	info := &types.StackDriftInformation{StackDriftStatus: types.StackDriftStatusInSync, LastCheckTimestamp: &checked}
+	never := &types.StackDriftInformation{StackDriftStatus: types.StackDriftStatusNotChecked}
+
+	tests := []struct {
+		name   string
+		opts   driftOptions
+		info   *types.StackDriftInformation
+		reuse  bool
+		detect bool
+	}{
+		{"default always detects", driftOptions{}, info, false, true},
+		{"cached reuses any age", driftOptions{cached: true}, info, true, false},
+		{"cached never checked", driftOptions{cached: true}, never, false, false},
+		{"max-age fresh", driftOptions{maxAge: 24 * time.Hour}, info, true, true},
+		{"max-age stale", driftOptions{cached: true, maxAge: time.Hour}, info, false, true},
+		{"max-age never checked", driftOptions{maxAge: time.Hour}, nil, false, true},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.reuse(tt.info, now); got != tt.reuse {
+			t.Errorf("%s: reuse = %v, want %v", tt.name, got, tt.reuse)
+		}
+		if got := tt.opts.detect(); got != tt.detect {
+			t.Errorf("%s: detect = %v, want %v", tt.name, got, tt.detect)
+		}
+	}
+}
+
+func TestResourceDriftReport(t *testing.T) {
+	results := []types.StackResourceDrift{
+		{LogicalResourceId: strPtr("AppSecurityGroup"), StackResourceDriftStatus: types.StackResourceDriftStatusModified},
+		{},
+		{LogicalResourceId: strPtr("Bucket"), StackResourceDriftStatus: types.StackResourceDriftStatusInSync},
+	}
+	errs := []error{nil, errors.New("Missing: throttled"), nil}
+
+	report := resourceDriftReport("my-stack", results, errs)
+	if report.DriftStatus != types.StackDriftStatusDrifted || report.DriftedResources != 1 {
+		t.Errorf("status = %s with %d drifted, want DRIFTED with 1", report.DriftStatus, report.DriftedResources)
+	}
+	if len(report.Resources) != 2 {
+		t.Errorf("resources = %d, want 2", len(report.Resources))
+	}
+	if report.Error != "Missing: throttled" {
+		t.Errorf("Error = %q", report.Error)
+	}
+
+	clean := resourceDriftReport("my-stack", results[2:], errs[2:])
+	if clean.DriftStatus != types.StackDriftStatusInSync || clean.Error != "" {
+		t.Errorf("unexpected clean report %+v", clean)
+	}
+}
+
+func TestIsDriftDetectionInProgress(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"operation in progress", &types.OperationInProgressException{Message: strPtr("Drift detection is running")}, true},
+		{"wrapped", fmt.Errorf("detect: %w", &types.OperationInProgressException{}), true},
+		{"validation error mentioning progress", &smithy.GenericAPIError{Code: "ValidationError", Message: "Stack is in UPDATE_IN_PROGRESS state"}, false},
+		{"other error", errors.New("operation in progress"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDriftDetectionInProgress(tt.err); got != tt.want {
+				t.Errorf("isDriftDetectionInProgress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriftReportFilterProperties(t *testing.T) {
+	report := driftReport{
+		Stack:            "app",
+		DriftStatus:      types.StackDriftStatusDrifted,
+		DriftedResources: 3,
+		Resources: []types.StackResourceDrift{
+			{
+				LogicalResourceId:        strPtr("Role"),
+				StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+				PropertyDifferences:      []types.PropertyDifference{{PropertyPath: strPtr("/Policies/0")}},
+			},
+			{
+				LogicalResourceId:        strPtr("Bucket"),
+				StackResourceDriftStatus: types.StackResourceDriftStatusModified,
+				PropertyDifferences:      []types.PropertyDifference{{PropertyPath: strPtr("/Tags/0")}},
+			},
+			{LogicalResourceId: strPtr("Queue"), StackResourceDriftStatus: types.StackResourceDriftStatusDeleted},
+		},
+	}
+
+	tags := report
+	tags.filterProperties([]string{"/Tags"})
+	if tags.DriftStatus != types.StackDriftStatusDrifted || tags.DriftedResources != 1 || len(tags.Resources) != 1 {
+		t.Errorf("filtered on /Tags: status %s, %d drifted, %d resources", tags.DriftStatus, tags.DriftedResources, len(tags.Resources))
+	}
+
+	none := report
+	none.filterProperties([]string{"/BucketName"})
+	if none.DriftStatus != types.StackDriftStatusInSync || none.DriftedResources != 0 {
+		t.Errorf("filtered on /BucketName: status %s, %d drifted", none.DriftStatus, none.DriftedResources)
+	}
+}
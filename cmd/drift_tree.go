@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// driftTarget is a stack to detect drift on. Nested stacks carry the stack and
+// logical ID they belong to.
+type driftTarget struct {
+	stack     string
+	logicalID string
+	parent    string
+	depth     int
+}
+
+// discoverNestedStacks expands the root stacks into a depth-first list that
+// includes every nested stack below them. Each level is listed concurrently,
+// up to concurrency stacks at a time. Roots that turn out to be nested in
+// another root are only listed under their parent.
+func discoverNestedStacks(ctx context.Context, client *cloudformation.Client, roots []string, concurrency int) []driftTarget {
+	children := make(map[string][]driftTarget)
+	seen := make(map[string]bool)
+	for _, r := range roots {
+		seen[r] = true
+	}
+
+	level := roots
+	for len(level) > 0 {
+		found := make([][]driftTarget, len(level))
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i, name := range level {
+			wg.Go(func() {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				resources, err := listStackResources(ctx, client, name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to list nested stacks of %s: %v\n", name, err)
+					return
+				}
+				found[i] = nestedStacks(name, resources)
+			})
+		}
+		wg.Wait()
+
+		var next []string
+		for i, name := range level {
+			children[name] = found[i]
+			for _, c := range found[i] {
+				if !seen[c.stack] {
+					seen[c.stack] = true
+					next = append(next, c.stack)
+				}
+			}
+		}
+		level = next
+	}
+
+	nested := make(map[string]bool)
+	for _, list := range children {
+		for _, c := range list {
+			nested[c.stack] = true
+		}
+	}
+
+	var targets []driftTarget
+	visited := make(map[string]bool)
+	var walk func(t driftTarget)
+	walk = func(t driftTarget) {
+		if visited[t.stack] {
+			return
+		}
+		visited[t.stack] = true
+		targets = append(targets, t)
+		for _, c := range children[t.stack] {
+			c.depth = t.depth + 1
+			walk(c)
+		}
+	}
+	for _, r := range roots {
+		if !nested[r] {
+			walk(driftTarget{stack: r})
+		}
+	}
+	return targets
+}
+
+// nestedStacks returns the nested stacks among the resources of a stack.
+func nestedStacks(parent string, resources []types.StackResourceSummary) []driftTarget {
+	var nested []driftTarget
+	for _, r := range resources {
+		if getValue(r.ResourceType) != "AWS::CloudFormation::Stack" || getValue(r.PhysicalResourceId) == "" {
+			continue
+		}
+		if r.ResourceStatus == types.ResourceStatusDeleteComplete {
+			continue
+		}
+		nested = append(nested, driftTarget{
+			stack:     stackNameFromARN(getValue(r.PhysicalResourceId)),
+			logicalID: getValue(r.LogicalResourceId),
+			parent:    parent,
+		})
+	}
+	return nested
+}
+
+// treePrefixes returns the tree-drawing prefix of each node, given the depth
+// of each node in depth-first order.
+func treePrefixes(depths []int) []string {
+	prefixes := make([]string, len(depths))
+	// open[d] is true while the latest node at depth d has siblings to come.
+	var open []bool
+	for i, depth := range depths {
+		if depth == 0 {
+			continue
+		}
+		last := true
+		for _, next := range depths[i+1:] {
+			if next < depth {
+				break
+			}
+			if next == depth {
+				last = false
+				break
+			}
+		}
+		for len(open) <= depth {
+			open = append(open, false)
+		}
+		open[depth] = !last
+
+		var b strings.Builder
+		for d := 1; d < depth; d++ {
+			if open[d] {
+				b.WriteString("│   ")
+			} else {
+				b.WriteString("    ")
+			}
+		}
+		if last {
+			b.WriteString("└── ")
+		} else {
+			b.WriteString("├── ")
+		}
+		prefixes[i] = b.String()
+	}
+	return prefixes
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestNestedStacks(t *testing.T) {
+	resources := []types.StackResourceSummary{
+		{LogicalResourceId: strPtr("Bucket"), ResourceType: strPtr("AWS::S3::Bucket"), PhysicalResourceId: strPtr("bucket")},
+		{
+			LogicalResourceId:  strPtr("Network"),
+			ResourceType:       strPtr("AWS::CloudFormation::Stack"),
+			PhysicalResourceId: strPtr("arn:aws:cloudformation:eu-west-1:123456789012:stack/root-Network-1A2B/abc"),
+			ResourceStatus:     types.ResourceStatusUpdateComplete,
+		},
+		{
+			LogicalResourceId:  strPtr("Old"),
+			ResourceType:       strPtr("AWS::CloudFormation::Stack"),
+			PhysicalResourceId: strPtr("arn:aws:cloudformation:eu-west-1:123456789012:stack/root-Old-3C4D/def"),
+			ResourceStatus:     types.ResourceStatusDeleteComplete,
+		},
+		{LogicalResourceId: strPtr("Pending"), ResourceType: strPtr("AWS::CloudFormation::Stack")},
+	}
+
+	nested := nestedStacks("root", resources)
+	if len(nested) != 1 {
+		t.Fatalf("nested = %+v, want only Network", nested)
+	}
+	if nested[0].stack != "root-Network-1A2B" || nested[0].logicalID != "Network" || nested[0].parent != "root" {
+		t.Errorf("unexpected nested stack %+v", nested[0])
+	}
+}
+
+func TestTreePrefixes(t *testing.T) {
+	reports := []driftReport{
+		{Stack: "root"},
+		{Stack: "network", depth: 1},
+		{Stack: "subnets", depth: 2},
+		{Stack: "routes", depth: 2},
+		{Stack: "data", depth: 1},
+		{Stack: "tables", depth: 2},
+		{Stack: "other-root"},
+	}
+
+	depths := make([]int, len(reports))
+	for i, r := range reports {
+		depths[i] = r.depth
+	}
+	var lines []string
+	for i, p := range treePrefixes(depths) {
+		lines = append(lines, p+reports[i].Stack)
+	}
+	want := strings.Join([]string{
+		"root",
+		"├── network",
+		"│   ├── subnets",
+		"│   └── routes",
+		"└── data",
+		"    └── tables",
+		"other-root",
+	}, "\n")
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("tree =\n%s\nwant\n%s", got, want)
+	}
+}
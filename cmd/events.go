@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
@@ -12,23 +13,28 @@ import (
 func EventsCmd() *cobra.Command {
 	var limit int
 	var failed bool
+	var output string
 
 	cmd := &cobra.Command{
 		Use:   "events <stack-name>",
 		Short: "List events for a CloudFormation stack",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			runEvents(args[0], limit, failed)
+			if output != "table" && output != "jsonl" {
+				fatalf("invalid --output %q (expected table or jsonl)\n", output)
+			}
+			runEvents(args[0], limit, failed, output)
 		},
 	}
 
 	cmd.Flags().IntVarP(&limit, "limit", "l", 0, "Maximum number of events to show (0 = all)")
 	cmd.Flags().BoolVarP(&failed, "failed", "f", false, "Show only failure events (root cause analysis)")
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format: table or jsonl (one JSON event per line, all fields)")
 
 	return cmd
 }
 
-func runEvents(stackName string, limit int, failed bool) {
+func runEvents(stackName string, limit int, failed bool, output string) {
 	ctx := context.Background()
 	client := mustClient(ctx)
 
@@ -41,6 +47,13 @@ func runEvents(stackName string, limit int, failed bool) {
 		events = filterFailedEvents(events)
 	}
 
+	if output == "jsonl" {
+		for _, e := range events {
+			writeJSONLine(os.Stdout, e)
+		}
+		return
+	}
+
 	if len(events) == 0 {
 		if failed {
 			fmt.Println("No failure events found")
@@ -56,8 +69,7 @@ func runEvents(stackName string, limit int, failed bool) {
 func filterFailedEvents(events []types.StackEvent) []types.StackEvent {
 	var filtered []types.StackEvent
 	for _, e := range events {
-		status := string(e.ResourceStatus)
-		if strings.HasSuffix(status, "_FAILED") {
+		if strings.HasSuffix(eventStatus(e), "_FAILED") {
 			filtered = append(filtered, e)
 		}
 	}
@@ -49,3 +49,16 @@ func TestFilterFailedEvents_Empty(t *testing.T) {
 		t.Errorf("expected 0 failed events, got %d", len(filtered))
 	}
 }
+
+func TestFilterFailedEvents_Hooks(t *testing.T) {
+	events := []types.StackEvent{
+		{HookType: strPtr("Org::S3::Encryption"), HookStatus: types.HookStatusHookCompleteSucceeded},
+		{HookType: strPtr("Org::S3::Encryption"), HookStatus: types.HookStatusHookCompleteFailed},
+		{HookType: strPtr("Org::S3::Encryption"), HookStatus: types.HookStatusHookFailed},
+	}
+
+	filtered := filterFailedEvents(events)
+	if len(filtered) != 2 {
+		t.Errorf("expected 2 failed hook events, got %d", len(filtered))
+	}
+}
@@ -12,217 +12,222 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// fixProgressInterval is how often the progress of a rollback is logged
+// while its status does not change.
+const fixProgressInterval = 30 * time.Second
+
 func FixCmd() *cobra.Command {
 	var roleARN string
 	var drift bool
+	var dryRun bool
+	var output string
+	var yes bool
+	var concurrency int
 
 	cmd := &cobra.Command{
 		Use:   "fix <stack-name>",
-		Short: "Fix a stuck UPDATE_ROLLBACK_FAILED stack",
-		Long: `Automates the continue-update-rollback process for stacks stuck in
-UPDATE_ROLLBACK_FAILED. If the stack contains Service Catalog provisioned products
-with stuck nested stacks, fixes them bottom-up automatically.
+		Short: "Fix a stuck stack",
+		Long: `Recovers a stack stuck in a failed or blocked state, with the recovery
+path that fits its status:
+
+  UPDATE_ROLLBACK_FAILED         continue the rollback, skipping failed resources
+  UPDATE_FAILED, CREATE_FAILED   roll back (left behind by --disable-rollback)
+  ROLLBACK_FAILED, DELETE_FAILED delete, retaining resources that fail to delete
+  ROLLBACK_COMPLETE              delete and recreate with the same definition
+  REVIEW_IN_PROGRESS             delete, if the stack has no change sets
+  UPDATE_IN_PROGRESS             cancel the update
+
+Stacks are archived to ~/.cfn/archive before being deleted. Every path except
+the two rollbacks asks for confirmation, unless --yes is given.
+
+For UPDATE_ROLLBACK_FAILED, nested stacks (AWS::CloudFormation::Stack) and the
+stacks of Service Catalog provisioned products are walked recursively and
+fixed bottom-up: Service Catalog stacks are rolled back on their own first,
+while nested stacks roll back with their parent, skipping their failed
+resources with the NestedStack.LogicalId syntax when needed. On a terminal,
+without --yes, the resources to skip are picked interactively. The status of
+the whole tree is shown at the end. Independent Service Catalog stacks are
+fixed concurrently, up to --concurrency at a time, each logging its own
+progress; a failure is reported at the end without stopping the others.
 
 Use --drift to run drift detection after the fix completes, showing what's out of
 sync from skipped resources.
 
+Use --dry-run to only show the plan: the stacks that are stuck, the order in
+which they are rolled back, the resources skipped in each attempt and the
+drift checks that follow. Nothing is changed. -o json prints it as JSON.
+
 Examples:
   cfn fix orch-b-default-nodegroup
   cfn fix orch-b-default-nodegroup --drift
+  cfn fix orch-b-default-nodegroup --dry-run
+  cfn fix orch-b-default-nodegroup --dry-run -o json
+  cfn fix my-failed-create --yes
   cfn fix orch-b-default-nodegroup --role-arn arn:aws:iam::123456789012:role/my-role`,
 		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			runFix(args[0], roleARN, drift)
+			if output != "text" && output != "json" {
+				fatalf("invalid --output %q (expected text or json)\n", output)
+			}
+			if concurrency < 1 {
+				fatalf("--concurrency must be at least 1\n")
+			}
+			if output == "json" && !dryRun {
+				fatalf("-o json requires --dry-run\n")
+			}
+			runFix(args[0], roleARN, drift, dryRun, yes, output, concurrency)
 		},
 	}
 
 	cmd.Flags().StringVar(&roleARN, "role-arn", "", "IAM role ARN for CloudFormation to assume")
 	cmd.Flags().BoolVar(&drift, "drift", false, "Run drift detection after fix completes")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the plan without changing anything")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip interactive confirmation")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 5, "Maximum number of Service Catalog stacks fixed at the same time")
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Plan output format with --dry-run: text or json")
 
 	return cmd
 }
 
-func runFix(stackName string, roleARN string, drift bool) {
+func runFix(stackName string, roleARN string, drift, dryRun, yes bool, output string, concurrency int) {
 	ctx := context.Background()
 	client := mustClient(ctx)
 
-	// Verify stack is in UPDATE_ROLLBACK_FAILED
-	descOut, err := client.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
+	stack, err := describeStack(ctx, client, stackName)
 	if err != nil {
 		fatalf("failed to describe stack %q: %v\n", stackName, err)
 	}
-	if len(descOut.Stacks) == 0 {
-		fatalf("stack %q not found\n", stackName)
+	if getValue(stack.ParentId) != "" {
+		fatalf("stack %q is a nested stack; run fix on its root stack %s\n", stackName, stackNameFromARN(getValue(stack.RootId)))
 	}
-	parentStack := descOut.Stacks[0]
-	if parentStack.StackStatus != types.StackStatusUpdateRollbackFailed {
-		fatalf("stack %q is in %s, expected UPDATE_ROLLBACK_FAILED\n", stackName, parentStack.StackStatus)
+
+	action := fixActionFor(stack.StackStatus)
+	switch action {
+	case "":
+		fatalf("stack %q is in %s, nothing to fix\n", stackName, stack.StackStatus)
+	case fixWait:
+		fatalf("stack %q is in %s; wait for it with 'cfn tail %s --until-complete'\n", stackName, stack.StackStatus, stackName)
 	}
 
-	// Find SC provisioned products in UPDATE_FAILED state
-	var scResources []types.StackResourceSummary
-	paginator := cloudformation.NewListStackResourcesPaginator(client, &cloudformation.ListStackResourcesInput{
-		StackName: &stackName,
-	})
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(ctx)
-		if err != nil {
-			fatalf("failed to list resources for stack %q: %v\n", stackName, err)
+	if action != fixContinueRollback {
+		if dryRun {
+			writeFixPlan(planStateFix(ctx, client, stack, action), output)
+			return
 		}
-		for _, r := range output.StackResourceSummaries {
-			if getValue(r.ResourceType) == "AWS::ServiceCatalog::CloudFormationProvisionedProduct" &&
-				r.ResourceStatus == types.ResourceStatusUpdateFailed {
-				scResources = append(scResources, r)
-			}
+		// A cancelled update or a rollback may get stuck rolling back, continue it below
+		if fixStackState(ctx, client, stack, action, roleARN, yes) != types.StackStatusUpdateRollbackFailed {
+			return
 		}
+		fmt.Fprintf(os.Stderr, "Continuing the rollback of stack %q...\n\n", stackName)
 	}
 
-	if len(scResources) == 0 {
-		fixContinueRollback(ctx, client, stackName, roleARN)
-		if drift {
-			runDrift(stackName, true)
-		}
-		printStackStatus(ctx, client, stackName)
+	interactive := !yes && stdinIsTTY()
+	root := buildFixTree(ctx, client, mustServiceCatalogClient(ctx), stackName, "", fixRoot, 0)
+	if dryRun {
+		writeFixPlan(planFix(root, drift), output)
 		return
 	}
+	if len(root.children) > 0 {
+		fmt.Fprintf(os.Stderr, "Stack tree:\n")
+		printFixTree(ctx, client, root)
+		fmt.Fprintln(os.Stderr)
+	}
 
-	// For each SC product, find and fix the underlying stack
-	for _, scRes := range scResources {
-		ppID := getValue(scRes.PhysicalResourceId)
-		logicalID := getValue(scRes.LogicalResourceId)
-		fmt.Fprintf(os.Stderr, "Found stuck SC product: %s (provisioned product: %s)\n", logicalID, ppID)
-
-		if ppID == "" {
-			fmt.Fprintf(os.Stderr, "  No physical resource ID — skipping\n")
-			continue
-		}
-
-		// Find the underlying SC stack by searching for stacks containing the pp-id
-		innerStacks, err := listStacks(ctx, client, nil, ppID, "", "", false)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "  Failed to find inner stack for %s: %v\n", ppID, err)
-			continue
-		}
-
-		var innerStack *types.StackSummary
-		for i, s := range innerStacks {
-			if s.StackStatus == types.StackStatusUpdateRollbackFailed {
-				innerStack = &innerStacks[i]
-				break
-			}
-		}
-
-		if innerStack == nil {
-			fmt.Fprintf(os.Stderr, "  No inner stack in UPDATE_ROLLBACK_FAILED found for %s\n", ppID)
-			continue
-		}
-
-		innerName := getValue(innerStack.StackName)
-		fmt.Fprintf(os.Stderr, "  Inner stack: %s (UPDATE_ROLLBACK_FAILED)\n", innerName)
-
-		// Show failed resources in the inner stack
-		showFailedResources(ctx, client, innerName)
-
-		// Attempt continue-update-rollback on inner stack
-		fmt.Fprintf(os.Stderr, "\n  Attempting continue-update-rollback on %s...\n", innerName)
-		success := attemptContinueRollback(ctx, client, innerName, nil, roleARN)
-		if !success {
-			// Retry skipping all UPDATE_FAILED resources
-			failedIDs := getFailedResourceIDs(ctx, client, innerName)
-			if len(failedIDs) == 0 {
-				fatalf("\n  Inner stack %s failed to roll back and no skippable resources found.\n", innerName)
-			}
-			fmt.Fprintf(os.Stderr, "  Retrying, skipping: %s\n", strings.Join(failedIDs, ", "))
-			success = attemptContinueRollback(ctx, client, innerName, failedIDs, roleARN)
-			if !success {
-				fatalf("\n  Inner stack %s failed to roll back even after skipping resources.\n", innerName)
-			}
+	// Fix bottom-up: Service Catalog stacks before the stacks containing
+	// their products, independent stacks concurrently. Nested stacks roll
+	// back with their parent.
+	log := &fixLog{w: os.Stderr}
+	fixer := rollbackFixer{client: client, roleARN: roleARN, interactive: interactive, log: log}
+	fixed, errs := fixStacks(ctx, fixer, fixWaves(root), concurrency, log)
+
+	// Fixing the parent may re-break Service Catalog stacks — fix them again if needed
+	var recheck []*fixNode
+	root.walk(func(n *fixNode) {
+		if n.kind == fixSC {
+			recheck = append(recheck, n)
 		}
-		fmt.Fprintf(os.Stderr, "  Inner stack %s rolled back successfully.\n\n", innerName)
+	})
+	if len(recheck) > 0 {
+		_, recheckErrs := fixStacks(ctx, fixer, [][]*fixNode{recheck}, concurrency, log)
+		errs = append(errs, recheckErrs...)
+	}
 
-		if drift {
-			fmt.Fprintf(os.Stderr, "  Running drift detection on %s...\n", innerName)
-			runDrift(innerName, true)
+	// Report the errors now, drift detection exits on failure
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d error(s):\n", len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
 		}
 	}
 
-	// Now fix the parent stack
-	fmt.Fprintf(os.Stderr, "Attempting continue-update-rollback on parent stack %s...\n", stackName)
-	fixContinueRollback(ctx, client, stackName, roleARN)
-
-	// Fixing the parent may re-break inner stacks — fix them again if needed
-	for _, scRes := range scResources {
-		ppID := getValue(scRes.PhysicalResourceId)
-		if ppID == "" {
-			continue
-		}
-		innerStacks, err := listStacks(ctx, client, nil, ppID, "", "", false)
-		if err != nil {
-			continue
-		}
-		for _, s := range innerStacks {
-			if s.StackStatus != types.StackStatusUpdateRollbackFailed {
-				continue
-			}
-			innerName := getValue(s.StackName)
-			fmt.Fprintf(os.Stderr, "\nInner stack %s is stuck again, fixing...\n", innerName)
-			showFailedResources(ctx, client, innerName)
-			fmt.Fprintf(os.Stderr, "  Attempting continue-update-rollback on %s...\n", innerName)
-			success := attemptContinueRollback(ctx, client, innerName, nil, roleARN)
-			if !success {
-				failedIDs := getFailedResourceIDs(ctx, client, innerName)
-				if len(failedIDs) == 0 {
-					fmt.Fprintf(os.Stderr, "  Failed and no skippable resources found.\n")
-					continue
-				}
-				fmt.Fprintf(os.Stderr, "  Retrying, skipping: %s\n", strings.Join(failedIDs, ", "))
-				success = attemptContinueRollback(ctx, client, innerName, failedIDs, roleARN)
-				if !success {
-					fmt.Fprintf(os.Stderr, "  Failed even after skipping.\n")
-				}
-			}
-			if success {
-				fmt.Fprintf(os.Stderr, "  Inner stack %s rolled back successfully.\n", innerName)
-			}
+	if drift {
+		for _, n := range fixed {
+			fmt.Fprintf(os.Stderr, "Running drift detection on %s...\n", n.stack)
+			runDrift(n.stack, driftOptions{wait: true})
 		}
 	}
 
 	// Show final status of all stacks
 	fmt.Fprintf(os.Stderr, "\nFinal stack status:\n")
-	printStackStatus(ctx, client, stackName)
-	for _, scRes := range scResources {
-		ppID := getValue(scRes.PhysicalResourceId)
-		if ppID == "" {
-			continue
-		}
-		innerStacks, err := listStacks(ctx, client, nil, ppID, "", "", false)
-		if err != nil {
-			continue
-		}
-		for _, s := range innerStacks {
-			printStackStatus(ctx, client, getValue(s.StackName))
+	printFixTree(ctx, client, root)
+
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeFixPlan prints a --dry-run plan as text or JSON.
+func writeFixPlan(plan fixPlan, output string) {
+	if output == "json" {
+		if err := plan.writeJSON(os.Stdout); err != nil {
+			fatalf("failed to write plan: %v\n", err)
 		}
+		return
 	}
+	plan.writeText(os.Stdout)
 }
 
-func fixContinueRollback(ctx context.Context, client *cloudformation.Client, stackName string, roleARN string) {
-	showFailedResources(ctx, client, stackName)
-	success := attemptContinueRollback(ctx, client, stackName, nil, roleARN)
-	if !success {
-		failedIDs := getFailedResourceIDs(ctx, client, stackName)
-		if len(failedIDs) == 0 {
-			fatalf("\nStack %s failed to roll back and no skippable resources found.\n", stackName)
+// fixRollback continues the rollback of an independent stack. If it fails
+// again, it retries skipping the failed resources of the stack and of the
+// nested stacks rolled back with it, all of them or, if interactive, those
+// picked by the user.
+func fixRollback(ctx context.Context, client *cloudformation.Client, n *fixNode, roleARN string, interactive bool, log *fixLog) error {
+	n.refreshNested(ctx, client)
+	n.printFailed(log)
+	if !attemptContinueRollback(ctx, client, n.stack, nil, roleARN, log) {
+		n.refreshNested(ctx, client)
+		skip := n.skipIDs()
+		if len(skip) == 0 {
+			return fmt.Errorf("stack %s failed to roll back and no skippable resources found", n.stack)
+		}
+		if interactive {
+			var picked []string
+			var err error
+			log.exclusive(func() {
+				fmt.Fprintf(os.Stderr, "\nStack %s failed to roll back.\n", n.stack)
+				picked, err = pickSkips(n.skipCandidates(), true, os.Stdin, os.Stderr)
+			})
+			if err != nil {
+				return err
+			}
+			if len(picked) == 0 {
+				return fmt.Errorf("stack %s failed to roll back and no resources were picked to skip", n.stack)
+			}
+			skip = picked
 		}
-		fmt.Fprintf(os.Stderr, "  Retrying, skipping: %s\n", strings.Join(failedIDs, ", "))
-		success = attemptContinueRollback(ctx, client, stackName, failedIDs, roleARN)
-		if !success {
-			fatalf("\nStack %s failed to roll back even after skipping resources.\n", stackName)
+		log.printf(n.stack, "retrying, skipping: %s", strings.Join(skip, ", "))
+		if !attemptContinueRollback(ctx, client, n.stack, skip, roleARN, log) {
+			return fmt.Errorf("stack %s failed to roll back even after skipping resources", n.stack)
 		}
 	}
-	fmt.Printf("Stack %q rollback complete.\n", stackName)
+	log.printf(n.stack, "%s", colorize("rollback complete", colorGreen))
+	return nil
 }
 
-func attemptContinueRollback(ctx context.Context, client *cloudformation.Client, stackName string, skip []string, roleARN string) bool {
+// attemptContinueRollback continues the rollback of a stack and waits for it,
+// logging status changes and, while it runs, the elapsed time every
+// fixProgressInterval.
+func attemptContinueRollback(ctx context.Context, client *cloudformation.Client, stackName string, skip []string, roleARN string, log *fixLog) bool {
 	input := &cloudformation.ContinueUpdateRollbackInput{
 		StackName: &stackName,
 	}
@@ -234,91 +239,44 @@ func attemptContinueRollback(ctx context.Context, client *cloudformation.Client,
 	}
 
 	if _, err := client.ContinueUpdateRollback(ctx, input); err != nil {
-		fmt.Fprintf(os.Stderr, "  Error: %v\n", err)
+		log.printf(stackName, "error: %v", err)
 		return false
 	}
 
 	// Poll until complete or failed
+	start := time.Now()
+	lastStatus := types.StackStatus("")
+	lastLog := start
 	for {
 		time.Sleep(3 * time.Second)
-		fmt.Fprint(os.Stderr, ".")
 
 		out, err := client.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "\n  Failed to check status: %v\n", err)
+			log.printf(stackName, "failed to check status: %v", err)
 			return false
 		}
 		if len(out.Stacks) == 0 {
-			fmt.Fprintf(os.Stderr, "\n  Stack not found\n")
+			log.printf(stackName, "stack not found")
 			return false
 		}
 
-		switch out.Stacks[0].StackStatus {
+		stack := out.Stacks[0]
+		elapsed := time.Since(start).Round(time.Second)
+		if stack.StackStatus != lastStatus || time.Since(lastLog) >= fixProgressInterval {
+			status := string(stack.StackStatus)
+			line := fmt.Sprintf("%s (%s)", colorize(status, colorForCFStatus(status)), elapsed)
+			if reason := getValue(stack.StackStatusReason); reason != "" && isTerminalStackStatus(stack.StackStatus) {
+				line += ": " + reason
+			}
+			log.printf(stackName, "%s", line)
+			lastStatus, lastLog = stack.StackStatus, time.Now()
+		}
+
+		switch stack.StackStatus {
 		case types.StackStatusUpdateRollbackComplete:
-			fmt.Fprintln(os.Stderr)
 			return true
 		case types.StackStatusUpdateRollbackFailed:
-			fmt.Fprintln(os.Stderr)
 			return false
 		}
 	}
 }
-
-func getFailedResourceIDs(ctx context.Context, client *cloudformation.Client, stackName string) []string {
-	var ids []string
-	paginator := cloudformation.NewListStackResourcesPaginator(client, &cloudformation.ListStackResourcesInput{
-		StackName: &stackName,
-	})
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil
-		}
-		for _, r := range output.StackResourceSummaries {
-			if r.ResourceStatus == types.ResourceStatusUpdateFailed {
-				ids = append(ids, getValue(r.LogicalResourceId))
-			}
-		}
-	}
-	return ids
-}
-
-func printStackStatus(ctx context.Context, client *cloudformation.Client, stackName string) {
-	out, err := client.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "  %-60s %s\n", stackName, "UNKNOWN")
-		return
-	}
-	if len(out.Stacks) == 0 {
-		fmt.Fprintf(os.Stderr, "  %-60s %s\n", stackName, "NOT FOUND")
-		return
-	}
-	fmt.Fprintf(os.Stderr, "  %-60s %s\n", stackName, out.Stacks[0].StackStatus)
-}
-
-func showFailedResources(ctx context.Context, client *cloudformation.Client, stackName string) {
-	paginator := cloudformation.NewListStackResourcesPaginator(client, &cloudformation.ListStackResourcesInput{
-		StackName: &stackName,
-	})
-	var found bool
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(ctx)
-		if err != nil {
-			return
-		}
-		for _, r := range output.StackResourceSummaries {
-			if r.ResourceStatus == types.ResourceStatusUpdateFailed {
-				if !found {
-					fmt.Fprintf(os.Stderr, "  Resources in UPDATE_FAILED state:\n")
-					found = true
-				}
-				reason := getValue(r.ResourceStatusReason)
-				if reason != "" {
-					fmt.Fprintf(os.Stderr, "    %s (%s) — %s\n", getValue(r.LogicalResourceId), getValue(r.ResourceType), reason)
-				} else {
-					fmt.Fprintf(os.Stderr, "    %s (%s)\n", getValue(r.LogicalResourceId), getValue(r.ResourceType))
-				}
-			}
-		}
-	}
-}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// fixLog prints the progress of stacks fixed concurrently, one line at a
+// time, prefixed by the stack name.
+type fixLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *fixLog) printf(stack, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "%s %s\n", colorize("["+stack+"]", stackColor(stack)), fmt.Sprintf(format, args...))
+}
+
+// exclusive runs fn while holding the log, so that prompts are not
+// interleaved with the progress of other stacks.
+func (l *fixLog) exclusive(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn()
+}
+
+// fixWaves groups the stacks rolled back on their own into waves that can be
+// fixed concurrently: a stack is in the wave after the last of the
+// independent stacks below it, so Service Catalog stacks are fixed before
+// the stacks containing their products.
+func fixWaves(root *fixNode) [][]*fixNode {
+	var waves [][]*fixNode
+	var height func(n *fixNode) int
+	height = func(n *fixNode) int {
+		h := 0
+		for _, c := range n.children {
+			if ch := height(c); ch > h {
+				h = ch
+			}
+		}
+		if !n.independent() {
+			return h
+		}
+		if h == len(waves) {
+			waves = append(waves, nil)
+		}
+		waves[h] = append(waves[h], n)
+		return h + 1
+	}
+	height(root)
+	return waves
+}
+
+// stackFixer inspects and fixes the stacks of a fix tree for fixStacks.
+type stackFixer interface {
+	// refresh updates the status of the stack.
+	refresh(ctx context.Context, n *fixNode) error
+	// fix continues the rollback of a stack in UPDATE_ROLLBACK_FAILED.
+	fix(ctx context.Context, n *fixNode) error
+}
+
+// rollbackFixer fixes stacks with ContinueUpdateRollback.
+type rollbackFixer struct {
+	client      *cloudformation.Client
+	roleARN     string
+	interactive bool
+	log         *fixLog
+}
+
+func (f rollbackFixer) refresh(ctx context.Context, n *fixNode) error {
+	_, err := n.refresh(ctx, f.client)
+	return err
+}
+
+func (f rollbackFixer) fix(ctx context.Context, n *fixNode) error {
+	return fixRollback(ctx, f.client, n, f.roleARN, f.interactive, f.log)
+}
+
+// fixStacks continues the rollback of the stacks in UPDATE_ROLLBACK_FAILED,
+// wave by wave, up to concurrency at a time. A stack is fixed even if stacks
+// below it failed, since their product resources can be skipped. It returns
+// the stacks fixed and the errors of the others.
+func fixStacks(ctx context.Context, fixer stackFixer, waves [][]*fixNode, concurrency int, log *fixLog) ([]*fixNode, []error) {
+	var fixed []*fixNode
+	var errs []error
+	var mu sync.Mutex
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, wave := range waves {
+		for _, n := range wave {
+			wg.Go(func() {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				if err := fixer.refresh(ctx, n); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("failed to inspect stack %s: %w", n.stack, err))
+					mu.Unlock()
+					return
+				}
+				if n.status != types.StackStatusUpdateRollbackFailed {
+					return
+				}
+				log.printf(n.stack, "attempting continue-update-rollback")
+				err := fixer.fix(ctx, n)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.printf(n.stack, "%s", colorize(err.Error(), colorRed))
+					errs = append(errs, err)
+					return
+				}
+				fixed = append(fixed, n)
+			})
+		}
+		wg.Wait()
+	}
+	return fixed, errs
+}
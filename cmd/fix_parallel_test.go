@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestFixWaves(t *testing.T) {
+	root := testFixTree()
+	// An SC product nested below the network stack, itself containing an SC
+	// product: it is fixed first, with the other leaf SC stacks.
+	network := root.children[0]
+	inner := &fixNode{stack: "SC-123-pp-inner", kind: fixSC, depth: 3}
+	network.children = append(network.children, &fixNode{stack: "SC-123-pp-mid", kind: fixSC, depth: 2, children: []*fixNode{inner}})
+
+	var got []string
+	for _, wave := range fixWaves(root) {
+		var names []string
+		for _, n := range wave {
+			names = append(names, n.stack)
+		}
+		got = append(got, strings.Join(names, ","))
+	}
+	want := "SC-123-pp-inner,SC-123-pp-abc,SC-123-pp-def | SC-123-pp-mid | app"
+	if strings.Join(got, " | ") != want {
+		t.Errorf("waves = %s, want %s", strings.Join(got, " | "), want)
+	}
+}
+
+func TestFixLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := &fixLog{w: &buf}
+	log.printf("app", "rollback %s", "complete")
+	log.exclusive(func() { buf.WriteString("prompt\n") })
+	if got := buf.String(); got != "[app] rollback complete\nprompt\n" {
+		t.Errorf("log = %q", got)
+	}
+}
+
+// fakeFixer records the stacks fixed and how many were fixed at once.
+type fakeFixer struct {
+	status   map[string]types.StackStatus
+	failFix  map[string]bool
+	failRead map[string]bool
+
+	mu      sync.Mutex
+	running int
+	peak    int
+	order   []string
+}
+
+func (f *fakeFixer) refresh(ctx context.Context, n *fixNode) error {
+	if f.failRead[n.stack] {
+		return errors.New("access denied")
+	}
+	n.status = f.status[n.stack]
+	return nil
+}
+
+func (f *fakeFixer) fix(ctx context.Context, n *fixNode) error {
+	f.mu.Lock()
+	f.running++
+	f.peak = max(f.peak, f.running)
+	f.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running--
+	f.order = append(f.order, n.stack)
+	if f.failFix[n.stack] {
+		return errors.New("stack " + n.stack + " failed to roll back")
+	}
+	return nil
+}
+
+func TestFixStacks(t *testing.T) {
+	var leaves []*fixNode
+	status := map[string]types.StackStatus{"app": types.StackStatusUpdateRollbackFailed}
+	for _, name := range []string{"sc-1", "sc-2", "sc-3", "sc-4", "sc-5", "sc-ok", "sc-denied"} {
+		leaves = append(leaves, &fixNode{stack: name, kind: fixSC})
+		status[name] = types.StackStatusUpdateRollbackFailed
+	}
+	status["sc-ok"] = types.StackStatusUpdateRollbackComplete
+	fixer := &fakeFixer{
+		status:   status,
+		failFix:  map[string]bool{"sc-3": true},
+		failRead: map[string]bool{"sc-denied": true},
+	}
+	waves := [][]*fixNode{leaves, {{stack: "app", kind: fixRoot}}}
+
+	fixed, errs := fixStacks(context.Background(), fixer, waves, 2, &fixLog{w: io.Discard})
+
+	if fixer.peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", fixer.peak)
+	}
+	var names []string
+	for _, n := range fixed {
+		names = append(names, n.stack)
+	}
+	slices.Sort(names)
+	if got := strings.Join(names, ","); got != "app,sc-1,sc-2,sc-4,sc-5" {
+		t.Errorf("fixed = %s", got)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("errs = %v, want 2", errs)
+	}
+	joined := errors.Join(errs...).Error()
+	for _, want := range []string{"stack sc-3 failed to roll back", "failed to inspect stack sc-denied: access denied"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("errors missing %q: %v", want, errs)
+		}
+	}
+	// The parent is fixed after every stack of the first wave
+	if last := fixer.order[len(fixer.order)-1]; last != "app" {
+		t.Errorf("fix order = %v, want app last", fixer.order)
+	}
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// fixPlan is what 'cfn fix' would do, shown by --dry-run.
+// Stacks in UPDATE_ROLLBACK_FAILED are planned as a tree of rollback steps;
+// other stuck states have a single action described by Actions and Notes.
+type fixPlan struct {
+	Stack       string        `json:"stack"`
+	Status      string        `json:"status,omitempty"`
+	Action      string        `json:"action"`
+	Stacks      []fixPlanNode `json:"stacks,omitempty"`
+	Steps       []fixStep     `json:"steps,omitempty"`
+	Recheck     []string      `json:"recheck,omitempty"`
+	DriftChecks []string      `json:"driftChecks,omitempty"`
+	Actions     []string      `json:"actions,omitempty"`
+	Notes       []string      `json:"notes,omitempty"`
+}
+
+// fixPlanNode is a stack of the tree with its current status.
+type fixPlanNode struct {
+	Stack     string   `json:"stack"`
+	LogicalID string   `json:"logicalResourceId,omitempty"`
+	Kind      string   `json:"kind"`
+	Status    string   `json:"status"`
+	Depth     int      `json:"depth"`
+	Failed    []string `json:"failedResources,omitempty"`
+}
+
+// fixStep is one continue-update-rollback of the plan. Steps of the same wave
+// run concurrently. The second attempt, if any, only runs when the first one
+// fails.
+type fixStep struct {
+	Wave     int          `json:"wave"`
+	Stack    string       `json:"stack"`
+	Kind     string       `json:"kind"`
+	Attempts []fixAttempt `json:"attempts"`
+}
+
+type fixAttempt struct {
+	Skip []string `json:"resourcesToSkip"`
+}
+
+// planFix builds the plan from the current state of the tree. Skips are
+// based on the resources currently in UPDATE_FAILED.
+func planFix(root *fixNode, drift bool) fixPlan {
+	plan := fixPlan{Stack: root.stack, Status: string(root.status), Action: fixContinueRollback}
+	root.walk(func(n *fixNode) {
+		node := fixPlanNode{Stack: n.stack, LogicalID: n.logicalID, Kind: n.kind, Status: string(n.status), Depth: n.depth}
+		for _, r := range n.failed {
+			node.Failed = append(node.Failed, getValue(r.LogicalResourceId))
+		}
+		plan.Stacks = append(plan.Stacks, node)
+	})
+
+	wave := 0
+	for _, nodes := range fixWaves(root) {
+		stuck := false
+		for _, n := range nodes {
+			if n.status != types.StackStatusUpdateRollbackFailed {
+				continue
+			}
+			if !stuck {
+				stuck = true
+				wave++
+			}
+			step := fixStep{Wave: wave, Stack: n.stack, Kind: n.kind, Attempts: []fixAttempt{{Skip: []string{}}}}
+			if skip := n.skipIDs(); len(skip) > 0 {
+				step.Attempts = append(step.Attempts, fixAttempt{Skip: skip})
+			}
+			plan.Steps = append(plan.Steps, step)
+			if n.kind == fixSC {
+				plan.Recheck = append(plan.Recheck, n.stack)
+			}
+			if drift {
+				plan.DriftChecks = append(plan.DriftChecks, n.stack)
+			}
+		}
+	}
+	return plan
+}
+
+func (p fixPlan) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func (p fixPlan) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Fix plan for stack %q (nothing is changed):\n\n", p.Stack)
+	if p.Action != fixContinueRollback {
+		p.writeActions(w)
+		return
+	}
+
+	depths := make([]int, len(p.Stacks))
+	for i, n := range p.Stacks {
+		depths[i] = n.Depth
+	}
+	fmt.Fprintf(w, "Stacks:\n")
+	for i, prefix := range treePrefixes(depths) {
+		n := p.Stacks[i]
+		name := prefix + n.Stack
+		if n.LogicalID != "" {
+			name += " (" + n.LogicalID + ")"
+		}
+		fmt.Fprintf(w, "  %-60s %s\n", name, colorize(n.Status, colorForCFStatus(n.Status)))
+		if len(n.Failed) > 0 {
+			fmt.Fprintf(w, "  %s  UPDATE_FAILED: %s\n", strings.Repeat(" ", len([]rune(prefix))), strings.Join(n.Failed, ", "))
+		}
+	}
+
+	fmt.Fprintf(w, "\nSteps:\n")
+	if len(p.Steps) == 0 {
+		fmt.Fprintf(w, "  none, no stack is in UPDATE_ROLLBACK_FAILED\n")
+	}
+	for i, s := range p.Steps {
+		if i == 0 || s.Wave != p.Steps[i-1].Wave {
+			fmt.Fprintf(w, "  Wave %d:\n", s.Wave)
+		}
+		kind := ""
+		if s.Kind == fixSC {
+			kind = " (Service Catalog product stack)"
+		}
+		fmt.Fprintf(w, "  %d. continue-update-rollback %s%s\n", i+1, s.Stack, kind)
+		for j, a := range s.Attempts {
+			when := ""
+			if j > 0 {
+				when = ", if the previous attempt fails"
+			}
+			skip := "no resources skipped"
+			if len(a.Skip) > 0 {
+				skip = "skip " + strings.Join(a.Skip, ", ")
+			}
+			fmt.Fprintf(w, "     attempt %d%s: %s\n", j+1, when, skip)
+		}
+		if len(s.Attempts) == 1 {
+			fmt.Fprintf(w, "     no resources to skip if it fails\n")
+		}
+	}
+	if len(p.Recheck) > 0 {
+		fmt.Fprintf(w, "\nThen re-check and fix again if stuck: %s\n", strings.Join(p.Recheck, ", "))
+	}
+	if len(p.DriftChecks) > 0 {
+		fmt.Fprintf(w, "Then detect drift on: %s\n", strings.Join(p.DriftChecks, ", "))
+	}
+}
+
+// writeActions prints the plan of a stack that is not in
+// UPDATE_ROLLBACK_FAILED.
+func (p fixPlan) writeActions(w io.Writer) {
+	fmt.Fprintf(w, "Status: %s\nAction: %s\n\nSteps:\n", colorize(p.Status, colorForCFStatus(p.Status)), p.Action)
+	for i, a := range p.Actions {
+		fmt.Fprintf(w, "  %d. %s\n", i+1, a)
+	}
+	if len(p.Notes) > 0 {
+		fmt.Fprintf(w, "\nNotes:\n")
+		for _, n := range p.Notes {
+			fmt.Fprintf(w, "  - %s\n", n)
+		}
+	}
+}
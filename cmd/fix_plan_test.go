@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func testFixTree() *fixNode {
+	return &fixNode{
+		stack:  "app",
+		kind:   fixRoot,
+		status: types.StackStatusUpdateRollbackFailed,
+		failed: []types.StackResourceSummary{failedResource("Product", "AWS::ServiceCatalog::CloudFormationProvisionedProduct")},
+		children: []*fixNode{
+			{
+				stack:     "app-Network-1",
+				logicalID: "Network",
+				kind:      fixNested,
+				status:    types.StackStatusUpdateRollbackFailed,
+				depth:     1,
+				failed:    []types.StackResourceSummary{failedResource("Subnet", "AWS::EC2::Subnet")},
+			},
+			{
+				stack:     "SC-123-pp-abc",
+				logicalID: "Product",
+				kind:      fixSC,
+				status:    types.StackStatusUpdateRollbackFailed,
+				depth:     1,
+			},
+			{
+				stack:     "SC-123-pp-def",
+				logicalID: "Other",
+				kind:      fixSC,
+				status:    types.StackStatusUpdateComplete,
+				depth:     1,
+			},
+		},
+	}
+}
+
+func TestPlanFix(t *testing.T) {
+	plan := planFix(testFixTree(), true)
+
+	var steps []string
+	for _, s := range plan.Steps {
+		var attempts []string
+		for _, a := range s.Attempts {
+			attempts = append(attempts, "["+strings.Join(a.Skip, " ")+"]")
+		}
+		steps = append(steps, s.Stack+strings.Join(attempts, ""))
+	}
+	want := "SC-123-pp-abc[] | app[][Product Network.Subnet]"
+	if got := strings.Join(steps, " | "); got != want {
+		t.Errorf("steps = %s, want %s", got, want)
+	}
+	if plan.Steps[0].Wave != 1 || plan.Steps[1].Wave != 2 {
+		t.Errorf("waves = %d, %d, want 1, 2", plan.Steps[0].Wave, plan.Steps[1].Wave)
+	}
+	if strings.Join(plan.Recheck, ",") != "SC-123-pp-abc" {
+		t.Errorf("recheck = %v", plan.Recheck)
+	}
+	if strings.Join(plan.DriftChecks, ",") != "SC-123-pp-abc,app" {
+		t.Errorf("drift checks = %v", plan.DriftChecks)
+	}
+	if len(plan.Stacks) != 4 {
+		t.Errorf("stacks = %d, want 4", len(plan.Stacks))
+	}
+}
+
+func TestFixPlanOutput(t *testing.T) {
+	plan := planFix(testFixTree(), false)
+
+	var text bytes.Buffer
+	plan.writeText(&text)
+	for _, want := range []string{
+		"├── app-Network-1 (Network)",
+		"UPDATE_FAILED: Subnet",
+		"Wave 1:\n  1. continue-update-rollback SC-123-pp-abc (Service Catalog product stack)",
+		"attempt 2, if the previous attempt fails: skip Product, Network.Subnet",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text plan does not contain %q:\n%s", want, text.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := plan.writeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded fixPlan
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Stack != "app" || len(decoded.Steps) != 2 || decoded.Steps[0].Attempts[0].Skip == nil {
+		t.Errorf("decoded plan = %+v", decoded)
+	}
+}
@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// Recovery actions of 'cfn fix', by stack status.
+const (
+	fixContinueRollback = "continue-update-rollback"
+	fixRollbackStack    = "rollback"
+	fixDeleteRetaining  = "delete-retaining"
+	fixRecreate         = "recreate"
+	fixDeleteReview     = "delete-review"
+	fixCancelUpdate     = "cancel-update"
+	fixWait             = "wait"
+)
+
+// fixActionFor returns how 'cfn fix' recovers a stack in the given status, or
+// "" if the stack is not stuck.
+func fixActionFor(status types.StackStatus) string {
+	switch status {
+	case types.StackStatusUpdateRollbackFailed:
+		return fixContinueRollback
+	case types.StackStatusUpdateFailed, types.StackStatusCreateFailed:
+		// Left behind by --disable-rollback
+		return fixRollbackStack
+	case types.StackStatusRollbackFailed, types.StackStatusDeleteFailed:
+		return fixDeleteRetaining
+	case types.StackStatusRollbackComplete:
+		return fixRecreate
+	case types.StackStatusReviewInProgress:
+		return fixDeleteReview
+	case types.StackStatusUpdateInProgress:
+		return fixCancelUpdate
+	}
+	if strings.HasSuffix(string(status), "_IN_PROGRESS") {
+		return fixWait
+	}
+	return ""
+}
+
+// fixActionSteps describes what an action does to a stack, for --dry-run.
+func fixActionSteps(action, stackName string) []string {
+	switch action {
+	case fixRollbackStack:
+		return []string{
+			fmt.Sprintf("roll back stack %s to its last stable state (RollbackStack)", stackName),
+		}
+	case fixDeleteRetaining:
+		return []string{
+			fmt.Sprintf("archive stack %s to %s", stackName, defaultArchiveDir()),
+			fmt.Sprintf("delete stack %s", stackName),
+			"if resources fail to delete, retry retaining them; they are left behind",
+		}
+	case fixRecreate:
+		return []string{
+			fmt.Sprintf("archive stack %s to %s", stackName, defaultArchiveDir()),
+			fmt.Sprintf("delete stack %s", stackName),
+			fmt.Sprintf("recreate stack %s from the archive", stackName),
+		}
+	case fixDeleteReview:
+		return []string{
+			fmt.Sprintf("delete stack %s, which has no resources", stackName),
+		}
+	case fixCancelUpdate:
+		return []string{
+			fmt.Sprintf("cancel the update of stack %s (CancelUpdateStack)", stackName),
+			"if the rollback fails, continue it as for UPDATE_ROLLBACK_FAILED",
+		}
+	}
+	return nil
+}
+
+// planStateFix builds the --dry-run plan of a stack that is not in
+// UPDATE_ROLLBACK_FAILED. Notes report what the fix would run into.
+func planStateFix(ctx context.Context, client *cloudformation.Client, stack types.Stack, action string) fixPlan {
+	stackName := getValue(stack.StackName)
+	plan := fixPlan{
+		Stack:   stackName,
+		Status:  string(stack.StackStatus),
+		Action:  action,
+		Actions: fixActionSteps(action, stackName),
+	}
+
+	switch action {
+	case fixDeleteRetaining:
+		if stack.StackStatus != types.StackStatusDeleteFailed {
+			break
+		}
+		resources, err := listStackResources(ctx, client, stackName)
+		if err != nil {
+			fatalf("failed to list resources for stack %q: %v\n", stackName, err)
+		}
+		for _, r := range deleteFailedResources(resources, nil) {
+			plan.Notes = append(plan.Notes, fmt.Sprintf("%s (%s) is in DELETE_FAILED and would be retained", getValue(r.LogicalResourceId), getValue(r.ResourceType)))
+		}
+	case fixRecreate:
+		backup, err := captureStack(ctx, client, stackName, "fix")
+		if err != nil {
+			fatalf("failed to read stack %q: %v\n", stackName, err)
+		}
+		if note := recreateBlocker(backup); note != "" {
+			plan.Notes = append(plan.Notes, note)
+		}
+	case fixDeleteReview:
+		changeSets, err := listChangeSets(ctx, client, stackName)
+		if err != nil {
+			fatalf("failed to list change sets for stack %q: %v\n", stackName, err)
+		}
+		if len(changeSets) > 0 {
+			plan.Notes = append(plan.Notes, fmt.Sprintf("stack has %d change set(s) and is not deleted: %s", len(changeSets), strings.Join(changeSets, ", ")))
+		}
+	}
+	return plan
+}
+
+// recreateBlocker returns why a stack cannot be recreated from its archive,
+// or "" if it can.
+func recreateBlocker(backup stackBackup) string {
+	if len(backup.stack.NoEchoParameters) > 0 {
+		return fmt.Sprintf("NoEcho parameters cannot be archived (%s); use 'cfn backup', 'cfn delete' and 'cfn restore --parameter' instead", strings.Join(backup.stack.NoEchoParameters, ", "))
+	}
+	return backup.templateTooLarge()
+}
+
+// fixStackState recovers a stack that is not in UPDATE_ROLLBACK_FAILED and
+// returns its final status.
+func fixStackState(ctx context.Context, client *cloudformation.Client, stack types.Stack, action, roleARN string, yes bool) types.StackStatus {
+	stackName := getValue(stack.StackName)
+	if reason := getValue(stack.StackStatusReason); reason != "" {
+		fmt.Fprintf(os.Stderr, "Stack %q is in %s: %s\n\n", stackName, stack.StackStatus, reason)
+	}
+
+	switch action {
+	case fixRollbackStack:
+		return fixRollbackFailedUpdate(ctx, client, stackName, roleARN)
+	case fixDeleteRetaining:
+		return fixDeleteStack(ctx, client, stackName, stack.StackStatus, yes)
+	case fixRecreate:
+		return fixRecreateStack(ctx, client, stackName, roleARN, yes)
+	case fixDeleteReview:
+		return fixReviewStack(ctx, client, stackName, yes)
+	case fixCancelUpdate:
+		return fixCancelStackUpdate(ctx, client, stackName, yes)
+	}
+	return stack.StackStatus
+}
+
+// fixRollbackFailedUpdate rolls back a stack whose update or creation failed
+// with rollback disabled. A rollback that fails in turn leaves the stack in
+// UPDATE_ROLLBACK_FAILED, which is returned for the rollback to be continued.
+func fixRollbackFailedUpdate(ctx context.Context, client *cloudformation.Client, stackName, roleARN string) types.StackStatus {
+	input := &cloudformation.RollbackStackInput{StackName: &stackName}
+	if roleARN != "" {
+		input.RoleARN = &roleARN
+	}
+	if _, err := client.RollbackStack(ctx, input); err != nil {
+		fatalf("failed to roll back stack %q: %v\n", stackName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Rolling back stack %q", stackName)
+	status, reason := waitForStack(ctx, client, stackName)
+	if status == types.StackStatusUpdateRollbackFailed {
+		fmt.Fprintf(os.Stderr, "Rollback of stack %q failed: %s\n", stackName, reason)
+		return status
+	}
+	if !isSuccessfulStackStatus(status) && status != types.StackStatusUpdateRollbackComplete && status != types.StackStatusRollbackComplete {
+		fatalf("rollback of stack %q ended in %s: %s\n", stackName, status, reason)
+	}
+	fmt.Fprintf(os.Stderr, "Stack %q is now %s\n", stackName, status)
+	return status
+}
+
+// fixDeleteStack deletes a stack whose rollback or deletion failed, retaining
+// the resources that fail to delete.
+func fixDeleteStack(ctx context.Context, client *cloudformation.Client, stackName string, status types.StackStatus, yes bool) types.StackStatus {
+	if !yes && !confirmDelete(stackName) {
+		fatalf("aborted\n")
+	}
+	path, err := archiveStack(ctx, client, stackName, defaultArchiveDir(), "fix")
+	if err != nil {
+		fatalf("failed to archive stack %q: %v\n", stackName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Archived stack %q to %s\n", stackName, path)
+
+	var orphans []types.StackResourceSummary
+	var retained []string
+	if status != types.StackStatusDeleteFailed {
+		if _, err := client.DeleteStack(ctx, &cloudformation.DeleteStackInput{StackName: &stackName}); err != nil {
+			fatalf("failed to delete stack %q: %v\n", stackName, err)
+		}
+		fmt.Fprintf(os.Stderr, "Deleting stack %q", stackName)
+		status, _ = waitForStack(ctx, client, stackName)
+	}
+	for status == types.StackStatusDeleteFailed {
+		failed := retryDeleteRetaining(ctx, client, stackName, retained, yes)
+		if len(failed) == 0 {
+			printOrphans(orphans)
+			fatalf("stack %q is still in DELETE_FAILED\n", stackName)
+		}
+		orphans = append(orphans, failed...)
+		for _, r := range failed {
+			retained = append(retained, getValue(r.LogicalResourceId))
+		}
+		fmt.Fprintf(os.Stderr, "Deleting stack %q", stackName)
+		status, _ = waitForStack(ctx, client, stackName)
+	}
+	if status != types.StackStatusDeleteComplete {
+		fatalf("deletion of stack %q ended in %s\n", stackName, status)
+	}
+	fmt.Fprintf(os.Stderr, "Stack %q deleted\n", stackName)
+	printOrphans(orphans)
+	return status
+}
+
+// fixRecreateStack deletes a stack whose creation rolled back and creates it
+// again with the same definition.
+func fixRecreateStack(ctx context.Context, client *cloudformation.Client, stackName, roleARN string, yes bool) types.StackStatus {
+	backup, err := captureStack(ctx, client, stackName, "fix")
+	if err != nil {
+		fatalf("failed to read stack %q: %v\n", stackName, err)
+	}
+	if blocker := recreateBlocker(backup); blocker != "" {
+		fatalf("cannot recreate stack %q: %s\n", stackName, blocker)
+	}
+	if roleARN != "" {
+		backup.stack.RoleARN = roleARN
+	}
+
+	if !yes && !confirm(fmt.Sprintf("Delete and recreate stack %q? Type 'yes' to confirm: ", stackName), os.Stdin) {
+		fatalf("aborted\n")
+	}
+	path := archivePath(defaultArchiveDir(), stackName, backup.stack.ArchivedAt)
+	if err := backup.writeDir(path); err != nil {
+		fatalf("failed to archive stack %q: %v\n", stackName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Archived stack %q to %s\n", stackName, path)
+
+	if _, err := client.DeleteStack(ctx, &cloudformation.DeleteStackInput{StackName: &stackName}); err != nil {
+		fatalf("failed to delete stack %q: %v\n", stackName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Deleting stack %q", stackName)
+	if status, reason := waitForStack(ctx, client, stackName); status != types.StackStatusDeleteComplete {
+		fatalf("deletion of stack %q ended in %s: %s\n", stackName, status, reason)
+	}
+
+	if err := createFromBackup(ctx, client, backup, stackName, backup.stack.Parameters, backup.stack.NotificationARNs); err != nil {
+		fatalf("failed to recreate stack %q: %v\nThe archive is at %s; use 'cfn restore' once the error is fixed.\n", stackName, err, path)
+	}
+	fmt.Fprintf(os.Stderr, "Creation started for stack %q\n", stackName)
+	tailUntilComplete(stackName)
+	return types.StackStatusCreateComplete
+}
+
+// fixReviewStack deletes a stack left in REVIEW_IN_PROGRESS by a change set
+// that was never executed, if it has no change sets left.
+func fixReviewStack(ctx context.Context, client *cloudformation.Client, stackName string, yes bool) types.StackStatus {
+	changeSets, err := listChangeSets(ctx, client, stackName)
+	if err != nil {
+		fatalf("failed to list change sets for stack %q: %v\n", stackName, err)
+	}
+	if len(changeSets) > 0 {
+		fatalf("stack %q has %d change set(s): %s\nExecute or delete them first.\n", stackName, len(changeSets), strings.Join(changeSets, ", "))
+	}
+	if !yes && !confirm(fmt.Sprintf("Stack %q has no resources and no change sets. Delete it? Type 'yes' to confirm: ", stackName), os.Stdin) {
+		fatalf("aborted\n")
+	}
+	if _, err := client.DeleteStack(ctx, &cloudformation.DeleteStackInput{StackName: &stackName}); err != nil {
+		fatalf("failed to delete stack %q: %v\n", stackName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Deleting stack %q", stackName)
+	status, reason := waitForStack(ctx, client, stackName)
+	if status != types.StackStatusDeleteComplete {
+		fatalf("deletion of stack %q ended in %s: %s\n", stackName, status, reason)
+	}
+	fmt.Fprintf(os.Stderr, "Stack %q deleted\n", stackName)
+	return status
+}
+
+// fixCancelStackUpdate cancels a running update. The stack then rolls back,
+// which may itself get stuck in UPDATE_ROLLBACK_FAILED.
+func fixCancelStackUpdate(ctx context.Context, client *cloudformation.Client, stackName string, yes bool) types.StackStatus {
+	if !yes && !confirm(fmt.Sprintf("Cancel the update of stack %q and roll it back? Type 'yes' to confirm: ", stackName), os.Stdin) {
+		fatalf("aborted\n")
+	}
+	if _, err := client.CancelUpdateStack(ctx, &cloudformation.CancelUpdateStackInput{StackName: &stackName}); err != nil {
+		fatalf("failed to cancel update of stack %q: %v\n", stackName, err)
+	}
+	fmt.Fprintf(os.Stderr, "Cancelling update of stack %q", stackName)
+	status, reason := waitForStack(ctx, client, stackName)
+	switch status {
+	case types.StackStatusUpdateRollbackComplete:
+		fmt.Fprintf(os.Stderr, "Stack %q rolled back\n", stackName)
+	case types.StackStatusUpdateRollbackFailed:
+		fmt.Fprintf(os.Stderr, "Rollback of stack %q failed: %s\n\n", stackName, reason)
+	default:
+		// The update finished before it could be cancelled
+		fmt.Fprintf(os.Stderr, "Stack %q is %s\n", stackName, status)
+	}
+	return status
+}
+
+// waitForStack polls a stack until no operation is running on it and returns
+// its status and reason. A deleted stack is reported as DELETE_COMPLETE.
+func waitForStack(ctx context.Context, client *cloudformation.Client, stackName string) (types.StackStatus, string) {
+	for {
+		time.Sleep(3 * time.Second)
+		fmt.Fprint(os.Stderr, ".")
+
+		stack, err := describeStack(ctx, client, stackName)
+		if err != nil {
+			if isStackNotFound(err) {
+				fmt.Fprintln(os.Stderr)
+				return types.StackStatusDeleteComplete, ""
+			}
+			fatalf("\nfailed to check status of stack %q: %v\n", stackName, err)
+		}
+		if isTerminalStackStatus(stack.StackStatus) {
+			fmt.Fprintln(os.Stderr)
+			return stack.StackStatus, getValue(stack.StackStatusReason)
+		}
+	}
+}
+
+// listChangeSets returns the names of the change sets of a stack.
+func listChangeSets(ctx context.Context, client *cloudformation.Client, stackName string) ([]string, error) {
+	var names []string
+	paginator := cloudformation.NewListChangeSetsPaginator(client, &cloudformation.ListChangeSetsInput{StackName: &stackName})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cs := range page.Summaries {
+			names = append(names, getValue(cs.ChangeSetName))
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestFixActionFor(t *testing.T) {
+	tests := []struct {
+		status types.StackStatus
+		want   string
+	}{
+		{types.StackStatusUpdateRollbackFailed, fixContinueRollback},
+		{types.StackStatusUpdateFailed, fixRollbackStack},
+		{types.StackStatusCreateFailed, fixRollbackStack},
+		{types.StackStatusRollbackFailed, fixDeleteRetaining},
+		{types.StackStatusDeleteFailed, fixDeleteRetaining},
+		{types.StackStatusRollbackComplete, fixRecreate},
+		{types.StackStatusReviewInProgress, fixDeleteReview},
+		{types.StackStatusUpdateInProgress, fixCancelUpdate},
+		{types.StackStatusUpdateRollbackInProgress, fixWait},
+		{types.StackStatusCreateInProgress, fixWait},
+		{types.StackStatusUpdateComplete, ""},
+		{types.StackStatusUpdateRollbackComplete, ""},
+	}
+	for _, tt := range tests {
+		if got := fixActionFor(tt.status); got != tt.want {
+			t.Errorf("fixActionFor(%s) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRecreateBlocker(t *testing.T) {
+	ok := stackBackup{template: "Resources: {}"}
+	if got := recreateBlocker(ok); got != "" {
+		t.Errorf("recreateBlocker = %q, want none", got)
+	}
+	noEcho := stackBackup{stack: stackArchive{NoEchoParameters: []string{"DbPassword"}}}
+	if got := recreateBlocker(noEcho); !strings.Contains(got, "DbPassword") {
+		t.Errorf("recreateBlocker = %q, want NoEcho parameter", got)
+	}
+	large := stackBackup{template: strings.Repeat("x", maxTemplateBody+1)}
+	if got := recreateBlocker(large); !strings.Contains(got, "bytes") {
+		t.Errorf("recreateBlocker = %q, want template size", got)
+	}
+}
+
+func TestFixStatePlanOutput(t *testing.T) {
+	plan := fixPlan{
+		Stack:   "app",
+		Status:  string(types.StackStatusReviewInProgress),
+		Action:  fixDeleteReview,
+		Actions: fixActionSteps(fixDeleteReview, "app"),
+		Notes:   []string{"stack has 1 change set(s) and is not deleted: cs-1"},
+	}
+	var text bytes.Buffer
+	plan.writeText(&text)
+	for _, want := range []string{
+		"Action: delete-review",
+		"1. delete stack app, which has no resources",
+		"- stack has 1 change set(s)",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text plan does not contain %q:\n%s", want, text.String())
+		}
+	}
+	if strings.Contains(text.String(), "continue-update-rollback") {
+		t.Errorf("text plan shows rollback steps:\n%s", text.String())
+	}
+}
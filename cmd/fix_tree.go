@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// Kinds of stacks in a fix tree.
+const (
+	fixRoot   = "root"
+	fixNested = "nested" // AWS::CloudFormation::Stack, rolled back through its parent
+	fixSC     = "sc"     // Service Catalog product stack, rolled back on its own
+)
+
+// fixNode is a stack in the tree walked by 'cfn fix'.
+type fixNode struct {
+	stack     string
+	logicalID string // logical ID of the resource in the parent stack
+	kind      string
+	status    types.StackStatus
+	depth     int
+	failed    []types.StackResourceSummary // resources in UPDATE_FAILED
+	children  []*fixNode
+}
+
+// independent reports whether the stack is rolled back by calling
+// ContinueUpdateRollback on it, rather than through its parent.
+func (n *fixNode) independent() bool {
+	return n.kind != fixNested
+}
+
+// walk calls fn for the node and its descendants, depth first.
+func (n *fixNode) walk(fn func(*fixNode)) {
+	fn(n)
+	for _, c := range n.children {
+		c.walk(fn)
+	}
+}
+
+// buildFixTree lists the nested and Service Catalog stacks below a stack,
+// recursively.
+func buildFixTree(ctx context.Context, client *cloudformation.Client, sc serviceCatalogAPI, stackName, logicalID, kind string, depth int) *fixNode {
+	n := &fixNode{stack: stackName, logicalID: logicalID, kind: kind, depth: depth}
+	resources, err := n.refresh(ctx, client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to inspect stack %s: %v\n", stackName, err)
+		return n
+	}
+	for _, r := range resources {
+		pid := getValue(r.PhysicalResourceId)
+		if pid == "" || r.ResourceStatus == types.ResourceStatusDeleteComplete {
+			continue
+		}
+		switch getValue(r.ResourceType) {
+		case "AWS::CloudFormation::Stack":
+			n.children = append(n.children, buildFixTree(ctx, client, sc, stackNameFromARN(pid), getValue(r.LogicalResourceId), fixNested, depth+1))
+		case "AWS::ServiceCatalog::CloudFormationProvisionedProduct":
+			if r.ResourceStatus != types.ResourceStatusUpdateFailed {
+				continue
+			}
+			arn, err := scStackARN(ctx, sc, pid)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to find the stack of provisioned product %s: %v\n", pid, err)
+				continue
+			}
+			n.children = append(n.children, buildFixTree(ctx, client, sc, stackNameFromARN(arn), getValue(r.LogicalResourceId), fixSC, depth+1))
+		}
+	}
+	return n
+}
+
+// refresh updates the status and failed resources of the stack and returns
+// its resources.
+func (n *fixNode) refresh(ctx context.Context, client *cloudformation.Client) ([]types.StackResourceSummary, error) {
+	stack, err := describeStack(ctx, client, n.stack)
+	if err != nil {
+		return nil, err
+	}
+	n.status = stack.StackStatus
+	resources, err := listStackResources(ctx, client, n.stack)
+	if err != nil {
+		return nil, err
+	}
+	n.failed = n.failed[:0]
+	for _, r := range resources {
+		if r.ResourceStatus == types.ResourceStatusUpdateFailed {
+			n.failed = append(n.failed, r)
+		}
+	}
+	return resources, nil
+}
+
+// refreshNested refreshes the stack and the nested stacks rolled back with
+// it.
+func (n *fixNode) refreshNested(ctx context.Context, client *cloudformation.Client) {
+	if _, err := n.refresh(ctx, client); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to inspect stack %s: %v\n", n.stack, err)
+	}
+	for _, c := range n.children {
+		if !c.independent() {
+			c.refreshNested(ctx, client)
+		}
+	}
+}
+
+// skipIDs returns the ResourcesToSkip that unblock the rollback of an
+// independent stack: its own failed resources and, with the
+// NestedStack.LogicalId syntax, those of the nested stacks rolled back with
+// it. Nested stack resources are not skipped themselves, since CloudFormation
+// only allows that once the nested stack is deleted.
+func (n *fixNode) skipIDs() []string {
+	var ids []string
+	for _, c := range n.skipCandidates() {
+		ids = append(ids, c.id)
+	}
+	return ids
+}
+
+// skipCandidates returns the failed resources behind skipIDs.
+func (n *fixNode) skipCandidates() []skipCandidate {
+	var candidates []skipCandidate
+	var collect func(n *fixNode, prefix string)
+	collect = func(n *fixNode, prefix string) {
+		for _, r := range n.failed {
+			if getValue(r.ResourceType) == "AWS::CloudFormation::Stack" {
+				continue
+			}
+			candidates = append(candidates, newSkipCandidate(prefix, r))
+		}
+		for _, c := range n.children {
+			if !c.independent() {
+				collect(c, prefix+c.logicalID+".")
+			}
+		}
+	}
+	collect(n, "")
+	return candidates
+}
+
+// printFailed logs the failed resources of the stack and its nested stacks.
+func (n *fixNode) printFailed(log *fixLog) {
+	var collect func(c *fixNode, prefix string)
+	collect = func(c *fixNode, prefix string) {
+		for _, r := range c.failed {
+			line := fmt.Sprintf("UPDATE_FAILED: %s%s (%s)", prefix, getValue(r.LogicalResourceId), getValue(r.ResourceType))
+			if reason := getValue(r.ResourceStatusReason); reason != "" {
+				line += " — " + reason
+			}
+			log.printf(n.stack, "%s", line)
+		}
+		for _, child := range c.children {
+			if !child.independent() {
+				collect(child, prefix+child.logicalID+".")
+			}
+		}
+	}
+	collect(n, "")
+}
+
+// printFixTree prints the status of every stack in the tree.
+func printFixTree(ctx context.Context, client *cloudformation.Client, root *fixNode) {
+	var nodes []*fixNode
+	root.walk(func(n *fixNode) { nodes = append(nodes, n) })
+	depths := make([]int, len(nodes))
+	for i, n := range nodes {
+		depths[i] = n.depth
+	}
+	for i, prefix := range treePrefixes(depths) {
+		n := nodes[i]
+		status := "UNKNOWN"
+		if stack, err := describeStack(ctx, client, n.stack); err == nil {
+			status = string(stack.StackStatus)
+		} else if isStackNotFound(err) {
+			status = "NOT FOUND"
+		}
+		name := prefix + n.stack
+		if n.logicalID != "" {
+			name += " (" + n.logicalID + ")"
+		}
+		fmt.Fprintf(os.Stderr, "  %-60s %s\n", name, colorize(status, colorForCFStatus(status)))
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func failedResource(logicalID, resourceType string) types.StackResourceSummary {
+	return types.StackResourceSummary{
+		LogicalResourceId: aws.String(logicalID),
+		ResourceType:      aws.String(resourceType),
+		ResourceStatus:    types.ResourceStatusUpdateFailed,
+	}
+}
+
+func TestFixNodeSkipIDs(t *testing.T) {
+	root := &fixNode{
+		stack: "app",
+		kind:  fixRoot,
+		failed: []types.StackResourceSummary{
+			failedResource("Queue", "AWS::SQS::Queue"),
+			failedResource("Network", "AWS::CloudFormation::Stack"),
+			failedResource("Product", "AWS::ServiceCatalog::CloudFormationProvisionedProduct"),
+		},
+		children: []*fixNode{
+			{
+				stack:     "app-Network-1",
+				logicalID: "Network",
+				kind:      fixNested,
+				failed: []types.StackResourceSummary{
+					failedResource("Subnet", "AWS::EC2::Subnet"),
+					failedResource("Routes", "AWS::CloudFormation::Stack"),
+				},
+				children: []*fixNode{
+					{
+						stack:     "app-Network-1-Routes-2",
+						logicalID: "Routes",
+						kind:      fixNested,
+						failed:    []types.StackResourceSummary{failedResource("Route", "AWS::EC2::Route")},
+					},
+				},
+			},
+			{
+				stack:     "SC-123-pp-abc",
+				logicalID: "Product",
+				kind:      fixSC,
+				failed:    []types.StackResourceSummary{failedResource("Role", "AWS::IAM::Role")},
+			},
+		},
+	}
+
+	want := "Queue,Product,Network.Subnet,Network.Routes.Route"
+	if got := strings.Join(root.skipIDs(), ","); got != want {
+		t.Errorf("root skipIDs = %s, want %s", got, want)
+	}
+	if got := strings.Join(root.children[1].skipIDs(), ","); got != "Role" {
+		t.Errorf("SC skipIDs = %s, want Role", got)
+	}
+}
@@ -3,16 +3,22 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
 	"strings"
 	"text/tabwriter"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudcontrol"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicecatalog"
+	"gopkg.in/yaml.v3"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/printers"
 )
@@ -28,7 +34,7 @@ func SetGlobalFlags(r string, nh bool) {
 	noHeaders = nh
 }
 
-func mustClient(ctx context.Context) *cloudformation.Client {
+func mustConfig(ctx context.Context) aws.Config {
 	cfg, err := config.LoadDefaultConfig(ctx, func(opts *config.LoadOptions) error {
 		if region != "" {
 			opts.Region = region
@@ -38,20 +44,45 @@ func mustClient(ctx context.Context) *cloudformation.Client {
 	if err != nil {
 		fatalf("failed to load AWS config: %v\n", err)
 	}
-	return cloudformation.NewFromConfig(cfg)
+	return cfg
+}
+
+func mustClient(ctx context.Context) *cloudformation.Client {
+	return cloudformation.NewFromConfig(mustConfig(ctx))
 }
 
 func mustCloudControlClient(ctx context.Context) *cloudcontrol.Client {
-	cfg, err := config.LoadDefaultConfig(ctx, func(opts *config.LoadOptions) error {
-		if region != "" {
-			opts.Region = region
-		}
+	return cloudcontrol.NewFromConfig(mustConfig(ctx))
+}
+
+func mustServiceCatalogClient(ctx context.Context) *servicecatalog.Client {
+	return servicecatalog.NewFromConfig(mustConfig(ctx))
+}
+
+// rateLimiter spaces out API calls made from several goroutines.
+type rateLimiter struct {
+	tick <-chan time.Time
+}
+
+// newRateLimiter allows up to perSecond calls per second; zero or less means
+// no limit.
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{tick: time.Tick(time.Second / time.Duration(perSecond))}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.tick == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.tick:
 		return nil
-	})
-	if err != nil {
-		fatalf("failed to load AWS config: %v\n", err)
 	}
-	return cloudcontrol.NewFromConfig(cfg)
 }
 
 func fatalf(format string, args ...any) {
@@ -90,6 +121,37 @@ func listStacks(ctx context.Context, client *cloudformation.Client, statusFilter
 	return all, nil
 }
 
+func describeStack(ctx context.Context, client *cloudformation.Client, stackName string) (types.Stack, error) {
+	out, err := client.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
+	if err != nil {
+		return types.Stack{}, err
+	}
+	if len(out.Stacks) == 0 {
+		return types.Stack{}, fmt.Errorf("stack %q not found", stackName)
+	}
+	return out.Stacks[0], nil
+}
+
+// isTerminalStackStatus reports whether a stack status is final, i.e. no
+// operation is running on the stack.
+func isTerminalStackStatus(status types.StackStatus) bool {
+	s := string(status)
+	return strings.HasSuffix(s, "_COMPLETE") || strings.HasSuffix(s, "_FAILED")
+}
+
+// isSuccessfulStackStatus reports whether a terminal stack status means the
+// last operation succeeded.
+func isSuccessfulStackStatus(status types.StackStatus) bool {
+	switch status {
+	case types.StackStatusCreateComplete,
+		types.StackStatusUpdateComplete,
+		types.StackStatusDeleteComplete,
+		types.StackStatusImportComplete:
+		return true
+	}
+	return false
+}
+
 func containsWithCase(haystack, needle string, ignoreCase bool) bool {
 	if ignoreCase {
 		return strings.Contains(strings.ToLower(haystack), strings.ToLower(needle))
@@ -124,6 +186,98 @@ func listEvents(ctx context.Context, client *cloudformation.Client, stackName st
 	return all, nil
 }
 
+// listEventsSince returns the events at or after since, newest first, without
+// paging through the rest of the stack history.
+func listEventsSince(ctx context.Context, client *cloudformation.Client, stackName string, since time.Time) ([]types.StackEvent, error) {
+	var all []types.StackEvent
+
+	paginator := cloudformation.NewDescribeStackEventsPaginator(client, &cloudformation.DescribeStackEventsInput{
+		StackName: &stackName,
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range output.StackEvents {
+			if e.Timestamp != nil && e.Timestamp.Before(since) {
+				return all, nil
+			}
+			all = append(all, e)
+		}
+	}
+	return all, nil
+}
+
+// listRecentOperations returns the events of the latest n operations of a
+// stack, newest first, without paging through the rest of its history.
+func listRecentOperations(ctx context.Context, client *cloudformation.Client, stackName string, n int) ([]types.StackEvent, error) {
+	var all []types.StackEvent
+
+	paginator := cloudformation.NewDescribeStackEventsPaginator(client, &cloudformation.DescribeStackEventsInput{
+		StackName: &stackName,
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, output.StackEvents...)
+		if events, ok := takeOperations(all, n); ok {
+			return events, nil
+		}
+	}
+	return all, nil
+}
+
+// takeOperations returns the newest-first events up to and including the
+// start of the nth operation, and whether that start was found.
+func takeOperations(events []types.StackEvent, n int) ([]types.StackEvent, bool) {
+	starts := 0
+	for i, e := range events {
+		if isStackEvent(e) && operationStartStatuses[e.ResourceStatus] {
+			starts++
+			if starts == n {
+				return events[:i+1], true
+			}
+		}
+	}
+	return events, false
+}
+
+func listStackResources(ctx context.Context, client *cloudformation.Client, stackName string) ([]types.StackResourceSummary, error) {
+	var all []types.StackResourceSummary
+
+	paginator := cloudformation.NewListStackResourcesPaginator(client, &cloudformation.ListStackResourcesInput{
+		StackName: &stackName,
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, output.StackResourceSummaries...)
+	}
+	return all, nil
+}
+
+// stackNameFromARN extracts the stack name from a stack ARN
+// (arn:aws:cloudformation:<region>:<account>:stack/<name>/<id>). Anything
+// that is not a stack ARN is returned unchanged.
+func stackNameFromARN(arn string) string {
+	if !strings.HasPrefix(arn, "arn:") {
+		return arn
+	}
+	parts := strings.Split(arn, "/")
+	if len(parts) < 2 {
+		return arn
+	}
+	return parts[1]
+}
+
 func buildStatusFilters(all, complete, deleted, inProgress, failed, rollback bool) []types.StackStatus {
 	// --all returns nil which means the AWS API default (everything except DELETE_COMPLETE)
 	if all {
@@ -254,6 +408,20 @@ func buildStatusFilters(all, complete, deleted, inProgress, failed, rollback boo
 	return filters
 }
 
+// parseTemplateBody parses a JSON or YAML template body.
+func parseTemplateBody(body string) (map[string]interface{}, error) {
+	if body == "" {
+		return nil, fmt.Errorf("empty template")
+	}
+	var template map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &template); err != nil {
+		if err := yaml.Unmarshal([]byte(body), &template); err != nil {
+			return nil, fmt.Errorf("failed to parse template: %v", err)
+		}
+	}
+	return template, nil
+}
+
 func makeTable(columns []string) *v1.Table {
 	table := &v1.Table{}
 	for _, c := range columns {
@@ -330,6 +498,13 @@ func printStacks(noHdrs bool, stacks []types.StackSummary, showUpdated bool) {
 	fmt.Fprint(os.Stdout, output)
 }
 
+// writeJSONLine writes v to w as a single line of JSON.
+func writeJSONLine(w io.Writer, v any) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fatalf("error writing JSON: %v\n", err)
+	}
+}
+
 func printEvents(noHdrs bool, events []types.StackEvent) {
 	table := makeTable([]string{"TIMESTAMP", "LOGICAL ID", "TYPE", "STATUS", "REASON"})
 	for _, e := range events {
@@ -342,8 +517,8 @@ func printEvents(noHdrs bool, events []types.StackEvent) {
 				ts,
 				getValue(e.LogicalResourceId),
 				getValue(e.ResourceType),
-				string(e.ResourceStatus),
-				getValue(e.ResourceStatusReason),
+				eventStatus(e),
+				eventReason(e),
 			},
 		})
 	}
@@ -359,9 +534,29 @@ const (
 	colorRed     = "\033[31m"
 	colorGreen   = "\033[32m"
 	colorYellow  = "\033[33m"
+	colorBlue    = "\033[34m"
 	colorMagenta = "\033[35m"
+	colorCyan    = "\033[36m"
 )
 
+// stackColors are used to tell stacks apart in merged event streams. Red,
+// green and yellow are left out because they already carry status meaning.
+var stackColors = []string{
+	colorCyan,
+	colorBlue,
+	colorMagenta,
+	colorBold + colorCyan,
+	colorBold + colorBlue,
+	colorBold + colorMagenta,
+}
+
+// stackColor picks a stable color for a stack name.
+func stackColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return stackColors[h.Sum32()%uint32(len(stackColors))]
+}
+
 func clearScreen() {
 	fmt.Print("\033[2J\033[H")
 }
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 )
@@ -176,3 +180,83 @@ func TestBuildStatusFilters_RollbackFailed(t *testing.T) {
 		t.Error("--rollback --failed should include UPDATE_ROLLBACK_FAILED")
 	}
 }
+
+func TestTakeOperations(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	// Newest first: a running update, a completed update, a completed create.
+	events := []types.StackEvent{
+		resourceEvent("Bucket", types.ResourceStatusUpdateInProgress, "", t0.Add(time.Minute)),
+		stackEvent(types.ResourceStatusUpdateInProgress, t0),
+		stackEvent(types.ResourceStatusUpdateComplete, t0.Add(-50*time.Minute)),
+		resourceEvent("Bucket", types.ResourceStatusUpdateComplete, "", t0.Add(-55*time.Minute)),
+		stackEvent(types.ResourceStatusUpdateInProgress, t0.Add(-time.Hour)),
+		stackEvent(types.ResourceStatusCreateComplete, t0.Add(-2*time.Hour)),
+		stackEvent(types.ResourceStatusCreateInProgress, t0.Add(-3*time.Hour)),
+	}
+
+	tests := []struct {
+		n      int
+		want   int
+		wantOK bool
+	}{
+		{1, 2, true},
+		{2, 5, true},
+		{3, 7, true},
+		{4, 7, false},
+	}
+	for _, tt := range tests {
+		got, ok := takeOperations(events, tt.n)
+		if len(got) != tt.want || ok != tt.wantOK {
+			t.Errorf("takeOperations(%d) = %d events, %v, want %d, %v", tt.n, len(got), ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestWriteJSONLine(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	failed := resourceEvent("Bucket", types.ResourceStatusCreateFailed, "Access denied", t0)
+	failed.PhysicalResourceId = strPtr("my-bucket")
+	failed.ClientRequestToken = strPtr("deploy-42")
+	failed.HookStatus = types.HookStatusHookCompleteFailed
+	failed.HookType = strPtr("Private::Guard::Bucket")
+	failed.HookFailureMode = types.HookFailureModeFail
+
+	var buf bytes.Buffer
+	writeJSONLine(&buf, failed)
+	writeJSONLine(&buf, stackEvent(types.ResourceStatusCreateInProgress, t0.Add(-time.Minute)))
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %d is not a JSON object: %v: %s", len(lines)+1, err, scanner.Text())
+		}
+		lines = append(lines, record)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	first := lines[0]
+	for key, want := range map[string]string{
+		"StackId":              testStackID,
+		"LogicalResourceId":    "Bucket",
+		"ResourceType":         "AWS::S3::Bucket",
+		"ResourceStatus":       "CREATE_FAILED",
+		"ResourceStatusReason": "Access denied",
+		"Timestamp":            "2024-01-01T10:00:00Z",
+		"PhysicalResourceId":   "my-bucket",
+		"ClientRequestToken":   "deploy-42",
+		"HookStatus":           "HOOK_COMPLETE_FAILED",
+		"HookType":             "Private::Guard::Bucket",
+		"HookFailureMode":      "FAIL",
+	} {
+		if got, _ := first[key].(string); got != want {
+			t.Errorf("%s = %v, want %q", key, first[key], want)
+		}
+	}
+	if lines[1]["ResourceStatus"] != "CREATE_IN_PROGRESS" {
+		t.Errorf("second line = %v", lines[1])
+	}
+}
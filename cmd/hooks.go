@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func HooksCmd() *cobra.Command {
+	var failed bool
+
+	cmd := &cobra.Command{
+		Use:   "hooks <stack-name>",
+		Short: "Show the CloudFormation Hooks invoked by the latest stack operation",
+		Long: `Show the CloudFormation Hooks invoked by the latest stack operation.
+
+Each row is one hook invocation against one resource, with its final status.
+Hooks in WARN failure mode report failures without stopping the deployment.
+
+Examples:
+  # Which hooks ran against which resources in the last deployment
+  cfn hooks my-stack
+
+  # Only the hooks that failed
+  cfn hooks my-stack --failed`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runHooks(args[0], failed)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&failed, "failed", "f", false, "Show only failed hook invocations")
+
+	return cmd
+}
+
+func runHooks(stackName string, failed bool) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+
+	events, err := listRecentOperations(ctx, client, stackName, 1)
+	if err != nil {
+		fatalf("failed to list events for stack %q: %v\n", stackName, err)
+	}
+
+	var invocations []hookInvocation
+	if ops := splitOperations(events); len(ops) > 0 {
+		invocations = summarizeHooks(ops[0])
+	}
+
+	succeeded, failures := 0, 0
+	var shown []hookInvocation
+	for _, h := range invocations {
+		switch {
+		case h.failed():
+			failures++
+		case h.Status == types.HookStatusHookCompleteSucceeded:
+			succeeded++
+		}
+		if !failed || h.failed() {
+			shown = append(shown, h)
+		}
+	}
+
+	if len(shown) == 0 {
+		if failed {
+			fmt.Println("No failed hook invocations in the latest operation")
+		} else {
+			fmt.Println("No hook invocations in the latest operation")
+		}
+		return
+	}
+
+	table := makeTable([]string{"RESOURCE", "RESOURCE TYPE", "HOOK", "INVOCATION POINT", "FAILURE MODE", "STATUS", "REASON"})
+	for _, h := range shown {
+		table.Rows = append(table.Rows, v1.TableRow{
+			Cells: []interface{}{
+				h.LogicalID,
+				h.ResourceType,
+				h.HookType,
+				string(h.InvocationPoint),
+				string(h.FailureMode),
+				string(h.Status),
+				h.Reason,
+			},
+		})
+	}
+	mustPrint(table)
+
+	fmt.Printf("\n%d hook invocation(s): %d succeeded, %d failed\n", len(invocations), succeeded, failures)
+}
+
+// hookInvocation is the latest state of one hook invoked against one resource.
+type hookInvocation struct {
+	LogicalID       string
+	ResourceType    string
+	HookType        string
+	InvocationPoint types.HookInvocationPoint
+	FailureMode     types.HookFailureMode
+	Status          types.HookStatus
+	Reason          string
+	Timestamp       time.Time
+}
+
+func (h hookInvocation) failed() bool {
+	return strings.HasSuffix(string(h.Status), "_FAILED")
+}
+
+// summarizeHooks collapses the hook events of a newest-first event list into
+// one entry per hook, resource and invocation point, in invocation order.
+func summarizeHooks(events []types.StackEvent) []hookInvocation {
+	var invocations []hookInvocation
+	index := make(map[string]int)
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if !isHookEvent(e) {
+			continue
+		}
+		key := getValue(e.HookType) + "|" + getValue(e.LogicalResourceId) + "|" + string(e.HookInvocationPoint)
+		pos, ok := index[key]
+		if !ok {
+			pos = len(invocations)
+			index[key] = pos
+			invocations = append(invocations, hookInvocation{
+				LogicalID:       getValue(e.LogicalResourceId),
+				ResourceType:    getValue(e.ResourceType),
+				HookType:        getValue(e.HookType),
+				InvocationPoint: e.HookInvocationPoint,
+			})
+		}
+		h := &invocations[pos]
+		if e.HookFailureMode != "" {
+			h.FailureMode = e.HookFailureMode
+		}
+		h.Status = e.HookStatus
+		h.Reason = getValue(e.HookStatusReason)
+		if e.Timestamp != nil {
+			h.Timestamp = *e.Timestamp
+		}
+	}
+	return invocations
+}
+
+// isHookEvent reports whether e records a hook invocation rather than a
+// resource status change.
+func isHookEvent(e types.StackEvent) bool {
+	return e.HookType != nil || e.HookStatus != ""
+}
+
+// eventStatus returns the status to display for an event: the hook status for
+// hook invocations, the resource status otherwise.
+func eventStatus(e types.StackEvent) string {
+	if isHookEvent(e) {
+		return string(e.HookStatus)
+	}
+	return string(e.ResourceStatus)
+}
+
+// eventReason returns the reason to display for an event. Hook invocations
+// are prefixed with the hook type, invocation point and failure mode.
+func eventReason(e types.StackEvent) string {
+	if !isHookEvent(e) {
+		return getValue(e.ResourceStatusReason)
+	}
+	details := []string{getValue(e.HookType)}
+	if e.HookInvocationPoint != "" {
+		details = append(details, string(e.HookInvocationPoint))
+	}
+	if e.HookFailureMode != "" {
+		details = append(details, string(e.HookFailureMode))
+	}
+	reason := "[hook " + strings.Join(details, " ") + "]"
+	if r := getValue(e.HookStatusReason); r != "" {
+		reason += " " + r
+	}
+	return reason
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func hookEvent(logicalID, hookType string, status types.HookStatus, reason string, ts time.Time) types.StackEvent {
+	return types.StackEvent{
+		StackId:             strPtr(testStackID),
+		LogicalResourceId:   strPtr(logicalID),
+		ResourceType:        strPtr("AWS::S3::Bucket"),
+		HookType:            strPtr(hookType),
+		HookStatus:          status,
+		HookStatusReason:    strPtr(reason),
+		HookInvocationPoint: types.HookInvocationPointPreProvision,
+		HookFailureMode:     types.HookFailureModeFail,
+		Timestamp:           &ts,
+	}
+}
+
+func TestSummarizeHooks(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	// Newest first.
+	events := []types.StackEvent{
+		resourceEvent("Bucket", types.ResourceStatusCreateFailed, "The following hook(s) failed: [Org::S3::Encryption]", t0.Add(4*time.Second)),
+		hookEvent("Bucket", "Org::S3::Encryption", types.HookStatusHookCompleteFailed, "Bucket is not encrypted", t0.Add(3*time.Second)),
+		hookEvent("Queue", "Org::Tags::Required", types.HookStatusHookCompleteSucceeded, "", t0.Add(2*time.Second)),
+		hookEvent("Bucket", "Org::S3::Encryption", types.HookStatusHookInProgress, "", t0.Add(time.Second)),
+		hookEvent("Queue", "Org::Tags::Required", types.HookStatusHookInProgress, "", t0),
+	}
+
+	hooks := summarizeHooks(events)
+	if len(hooks) != 2 {
+		t.Fatalf("got %d invocations, want 2: %+v", len(hooks), hooks)
+	}
+	if hooks[0].LogicalID != "Queue" || hooks[0].Status != types.HookStatusHookCompleteSucceeded || hooks[0].failed() {
+		t.Errorf("unexpected first invocation %+v", hooks[0])
+	}
+	if hooks[1].LogicalID != "Bucket" || !hooks[1].failed() || hooks[1].Reason != "Bucket is not encrypted" {
+		t.Errorf("unexpected second invocation %+v", hooks[1])
+	}
+	if hooks[1].FailureMode != types.HookFailureModeFail || hooks[1].InvocationPoint != types.HookInvocationPointPreProvision {
+		t.Errorf("missing hook details %+v", hooks[1])
+	}
+}
+
+func TestEventStatusAndReason(t *testing.T) {
+	ts := time.Now()
+	e := hookEvent("Bucket", "Org::S3::Encryption", types.HookStatusHookCompleteFailed, "Bucket is not encrypted", ts)
+	if got := eventStatus(e); got != "HOOK_COMPLETE_FAILED" {
+		t.Errorf("eventStatus = %q", got)
+	}
+	if got, want := eventReason(e), "[hook Org::S3::Encryption PRE_PROVISION FAIL] Bucket is not encrypted"; got != want {
+		t.Errorf("eventReason = %q, want %q", got, want)
+	}
+
+	r := resourceEvent("Bucket", types.ResourceStatusCreateFailed, "Access Denied", ts)
+	if eventStatus(r) != "CREATE_FAILED" || eventReason(r) != "Access Denied" {
+		t.Errorf("resource event rendered as %q / %q", eventStatus(r), eventReason(r))
+	}
+}
+
+func TestHookEventsDoNotAffectStackStatus(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	stackHook := hookEvent("my-stack", "Org::Stack::Policy", types.HookStatusHookCompleteFailed, "denied", t0.Add(time.Minute))
+	stackHook.ResourceType = strPtr("AWS::CloudFormation::Stack")
+	stackHook.PhysicalResourceId = strPtr(testStackID)
+	if isStackEvent(stackHook) {
+		t.Fatal("a stack-level hook invocation should not be a stack event")
+	}
+
+	events := []types.StackEvent{
+		stackEvent(types.ResourceStatusRollbackComplete, t0.Add(2*time.Minute)),
+		stackHook,
+		stackEvent(types.ResourceStatusCreateInProgress, t0),
+	}
+	summary := summarizeOperation(events)
+	if summary.Status != types.StackStatusRollbackComplete {
+		t.Errorf("Status = %s, want ROLLBACK_COMPLETE", summary.Status)
+	}
+	if len(summary.FailedHooks) != 1 || summary.FailedHooks[0].HookType != "Org::Stack::Policy" {
+		t.Errorf("FailedHooks = %+v", summary.FailedHooks)
+	}
+}
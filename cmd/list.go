@@ -2,10 +2,10 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
+	"path"
 	"sort"
 	"strings"
 	"syscall"
@@ -14,28 +14,95 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 var (
-	filterAll        bool
-	filterComplete   bool
-	filterDeleted    bool
-	filterInProgress bool
-	filterFailed     bool
-	filterRollback   bool
-	ignoreCase       bool
-	nameFilter       string
-	descContains     string
-	descNotContains  string
-	namesOnly        bool
-	sortUpdated      bool
-	resourceType     string
-	resourceName     string
-	properties       []string
-	watchInterval    time.Duration
+	listFilters   stackFilters
+	nameFilter    string
+	namesOnly     bool
+	sortUpdated   bool
+	resourceType  string
+	resourceName  string
+	properties    []string
+	watchInterval time.Duration
 )
 
+// stackFilters holds the stack selection flags of `cfn list`. Commands that
+// operate on several stacks at once register the same flags so stacks are
+// selected the same way everywhere.
+type stackFilters struct {
+	all             bool
+	complete        bool
+	deleted         bool
+	inProgress      bool
+	failed          bool
+	rollback        bool
+	ignoreCase      bool
+	descContains    string
+	descNotContains string
+}
+
+func (f *stackFilters) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&f.all, "all", "A", false, "Show all stacks (overrides other status filters)")
+	cmd.Flags().BoolVarP(&f.complete, "complete", "C", false, "Filter complete stacks (*_COMPLETE statuses)")
+	cmd.Flags().BoolVarP(&f.deleted, "deleted", "D", false, "Filter deleted stacks (DELETE_* statuses)")
+	cmd.Flags().BoolVarP(&f.inProgress, "in-progress", "P", false, "Filter in-progress stacks (*_IN_PROGRESS statuses)")
+	cmd.Flags().BoolVarP(&f.failed, "failed", "F", false, "Filter failed stacks (*_FAILED statuses)")
+	cmd.Flags().BoolVarP(&f.rollback, "rollback", "R", false, "Filter rollback stacks (*ROLLBACK* statuses); combine with -F/-C/-P to narrow")
+	cmd.Flags().BoolVarP(&f.ignoreCase, "ignore-case", "i", false, "Use case-insensitive matching for text filters")
+	cmd.Flags().StringVar(&f.descContains, "desc", "", "Filter stacks whose description contains this string")
+	cmd.Flags().StringVar(&f.descNotContains, "no-desc", "", "Exclude stacks whose description contains this string")
+}
+
+func (f stackFilters) statusFilters() []types.StackStatus {
+	return buildStatusFilters(f.all, f.complete, f.deleted, f.inProgress, f.failed, f.rollback)
+}
+
+// hasStatusFilter reports whether any status flag was given.
+func (f stackFilters) hasStatusFilter() bool {
+	return f.all || f.complete || f.deleted || f.inProgress || f.failed || f.rollback
+}
+
+// isSet reports whether any filter flag was given.
+func (f stackFilters) isSet() bool {
+	return f.hasStatusFilter() || f.descContains != "" || f.descNotContains != ""
+}
+
+// matchStacks lists the stacks whose name matches the glob pattern (as in
+// path.Match) and that pass the filters. An empty pattern matches every stack.
+func matchStacks(ctx context.Context, client *cloudformation.Client, pattern string, f stackFilters) ([]types.StackSummary, error) {
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	stacks, err := listStacks(ctx, client, f.statusFilters(), "", f.descContains, f.descNotContains, f.ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []types.StackSummary
+	for _, s := range stacks {
+		if matchStackName(getValue(s.StackName), pattern, f.ignoreCase) {
+			matched = append(matched, s)
+		}
+	}
+	return matched, nil
+}
+
+func matchStackName(name, pattern string, ignoreCase bool) bool {
+	if pattern == "" {
+		return true
+	}
+	if ignoreCase {
+		name = strings.ToLower(name)
+		pattern = strings.ToLower(pattern)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
 func ListCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "list [name-filter]",
@@ -67,15 +134,7 @@ Examples:
 		Run:  runList,
 	}
 
-	cmd.Flags().BoolVarP(&filterAll, "all", "A", false, "Show all stacks (overrides other status filters)")
-	cmd.Flags().BoolVarP(&filterComplete, "complete", "C", false, "Filter complete stacks (*_COMPLETE statuses)")
-	cmd.Flags().BoolVarP(&filterDeleted, "deleted", "D", false, "Filter deleted stacks (DELETE_* statuses)")
-	cmd.Flags().BoolVarP(&filterInProgress, "in-progress", "P", false, "Filter in-progress stacks (*_IN_PROGRESS statuses)")
-	cmd.Flags().BoolVarP(&filterFailed, "failed", "F", false, "Filter failed stacks (*_FAILED statuses)")
-	cmd.Flags().BoolVarP(&filterRollback, "rollback", "R", false, "Filter rollback stacks (*ROLLBACK* statuses); combine with -F/-C/-P to narrow")
-	cmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "Use case-insensitive matching for text filters")
-	cmd.Flags().StringVar(&descContains, "desc", "", "Filter stacks whose description contains this string")
-	cmd.Flags().StringVar(&descNotContains, "no-desc", "", "Exclude stacks whose description contains this string")
+	listFilters.register(cmd)
 	cmd.Flags().BoolVarP(&namesOnly, "names-only", "1", false, "Print only stack names, one per line")
 	cmd.Flags().BoolVarP(&sortUpdated, "sort-updated", "u", false, "Sort by last updated time (most recent first)")
 	cmd.Flags().StringVarP(&resourceType, "type", "t", "", "Search for resource type (e.g., AWS::S3::Bucket)")
@@ -100,8 +159,8 @@ func runList(cmd *cobra.Command, args []string) {
 	isResourceSearch := resourceType != "" || resourceName != "" || len(properties) > 0
 
 	// For resource search, default to all stacks unless user specifies status filters
-	statusFilters := buildStatusFilters(filterAll, filterComplete, filterDeleted, filterInProgress, filterFailed, filterRollback)
-	if isResourceSearch && !filterAll && !filterComplete && !filterDeleted && !filterInProgress && !filterFailed && !filterRollback {
+	statusFilters := listFilters.statusFilters()
+	if isResourceSearch && !listFilters.hasStatusFilter() {
 		// No status filters specified and doing resource search - search all stacks (including DELETE_COMPLETE)
 		statusFilters = nil
 	}
@@ -112,7 +171,7 @@ func runList(cmd *cobra.Command, args []string) {
 		}
 
 		collect := func() []types.StackSummary {
-			s, err := listStacks(ctx, client, statusFilters, nameFilter, descContains, descNotContains, ignoreCase)
+			s, err := listStacks(ctx, client, statusFilters, nameFilter, listFilters.descContains, listFilters.descNotContains, listFilters.ignoreCase)
 			if err != nil {
 				return nil
 			}
@@ -158,7 +217,7 @@ func runList(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	stacks, err := listStacks(ctx, client, statusFilters, nameFilter, descContains, descNotContains, ignoreCase)
+	stacks, err := listStacks(ctx, client, statusFilters, nameFilter, listFilters.descContains, listFilters.descNotContains, listFilters.ignoreCase)
 	if err != nil {
 		fatalf("failed to list stacks: %v\n", err)
 	}
@@ -238,7 +297,7 @@ func runResourceSearch(ctx context.Context, client *cloudformation.Client, stack
 			continue
 		}
 
-		hasMatch, err := searchStackTemplate(ctx, client, *stack.StackName, resourceType, resourceName, propertyFilters, ignoreCase)
+		hasMatch, err := searchStackTemplate(ctx, client, *stack.StackName, resourceType, resourceName, propertyFilters, listFilters.ignoreCase)
 		if err != nil {
 			// Skip stacks we can't access
 			continue
@@ -297,18 +356,9 @@ func searchStackTemplate(ctx context.Context, client *cloudformation.Client, sta
 		return false, err
 	}
 
-	body := getValue(output.TemplateBody)
-	if body == "" {
-		return false, fmt.Errorf("empty template")
-	}
-
-	// Parse template (try JSON first, then YAML)
-	var template map[string]interface{}
-	if err := json.Unmarshal([]byte(body), &template); err != nil {
-		// Try YAML
-		if err := yaml.Unmarshal([]byte(body), &template); err != nil {
-			return false, fmt.Errorf("failed to parse template: %v", err)
-		}
+	template, err := parseTemplateBody(getValue(output.TemplateBody))
+	if err != nil {
+		return false, err
 	}
 
 	// Search for resources
@@ -0,0 +1,47 @@
+package cmd
+
+import "testing"
+
+func TestMatchStackName(t *testing.T) {
+	tests := []struct {
+		name       string
+		pattern    string
+		ignoreCase bool
+		want       bool
+	}{
+		{"orch-b-network", "orch-b-*", false, true},
+		{"orch-a-network", "orch-b-*", false, false},
+		{"ORCH-B-network", "orch-b-*", false, false},
+		{"ORCH-B-network", "orch-b-*", true, true},
+		{"pr-1234-api", "pr-123?-*", false, true},
+		{"anything", "", false, true},
+		{"orch-b-network", "orch-b-network", false, true},
+		{"orch-b-network-extra", "orch-b-network", false, false},
+	}
+	for _, tt := range tests {
+		if got := matchStackName(tt.name, tt.pattern, tt.ignoreCase); got != tt.want {
+			t.Errorf("matchStackName(%q, %q, %v) = %v, want %v", tt.name, tt.pattern, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
+
+func TestStackFiltersIsSet(t *testing.T) {
+	if (stackFilters{}).isSet() {
+		t.Error("empty filters should not be set")
+	}
+	if !(stackFilters{descContains: "prod"}).isSet() {
+		t.Error("--desc should count as a filter")
+	}
+	if !(stackFilters{inProgress: true}).hasStatusFilter() {
+		t.Error("--in-progress should count as a status filter")
+	}
+	if (stackFilters{ignoreCase: true}).isSet() {
+		t.Error("--ignore-case alone should not count as a filter")
+	}
+}
+
+func TestStackColorStable(t *testing.T) {
+	if stackColor("orch-b-network") != stackColor("orch-b-network") {
+		t.Error("expected the same color for the same stack name")
+	}
+}
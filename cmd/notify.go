@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/spf13/cobra"
+)
+
+// Notification triggers, as accepted by --notify-on.
+const (
+	triggerStatus  = "status"  // the stack reached a terminal status
+	triggerFailure = "failure" // first failed resource of an operation
+	triggerStuck   = "stuck"   // a resource has been in progress too long
+)
+
+var allTriggers = []string{triggerStatus, triggerFailure, triggerStuck}
+
+// notifyOptions holds the notification flags shared by long-running commands.
+type notifyOptions struct {
+	webhooks []string
+	execs    []string
+	bell     bool
+	on       []string
+}
+
+func (o *notifyOptions) register(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&o.webhooks, "notify-webhook", []string{}, "POST a JSON notification (Slack-compatible) to this URL (repeatable)")
+	cmd.Flags().StringArrayVar(&o.execs, "notify-exec", []string{}, "Run this shell command on notification, with details in CFN_* environment variables (repeatable)")
+	cmd.Flags().BoolVar(&o.bell, "notify-bell", false, "Ring the terminal bell on notification")
+	cmd.Flags().StringSliceVar(&o.on, "notify-on", allTriggers, "Notification triggers: status, failure, stuck")
+}
+
+// validate checks the --notify-on values.
+func (o notifyOptions) validate() error {
+	for _, t := range o.on {
+		if !slices.Contains(allTriggers, t) {
+			return fmt.Errorf("invalid --notify-on trigger %q (expected %s)", t, strings.Join(allTriggers, ", "))
+		}
+	}
+	return nil
+}
+
+// notification is the payload sent to webhooks. Text makes it usable as a
+// Slack incoming webhook message; the other fields are for everything else.
+type notification struct {
+	Text         string    `json:"text"`
+	Trigger      string    `json:"trigger"`
+	Stack        string    `json:"stack"`
+	Status       string    `json:"status,omitempty"`
+	LogicalID    string    `json:"logicalResourceId,omitempty"`
+	ResourceType string    `json:"resourceType,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// env returns the notification as CFN_* environment variables for
+// --notify-exec commands.
+func (n notification) env() []string {
+	return []string{
+		"CFN_TRIGGER=" + n.Trigger,
+		"CFN_STACK=" + n.Stack,
+		"CFN_STATUS=" + n.Status,
+		"CFN_LOGICAL_ID=" + n.LogicalID,
+		"CFN_RESOURCE_TYPE=" + n.ResourceType,
+		"CFN_REASON=" + n.Reason,
+		"CFN_TIMESTAMP=" + n.Timestamp.Format(time.RFC3339),
+		"CFN_MESSAGE=" + n.Text,
+	}
+}
+
+// notifier delivers notifications to the configured webhooks, commands and
+// terminal bell. Delivery errors are reported as warnings and never abort the
+// command being watched.
+type notifier struct {
+	opts   notifyOptions
+	client *http.Client
+
+	// failed records the stacks whose current operation already produced a
+	// failure notification. Bulk deletions observe several stacks at once.
+	mu     sync.Mutex
+	failed map[string]bool
+}
+
+func newNotifier(opts notifyOptions) *notifier {
+	return &notifier{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		failed: make(map[string]bool),
+	}
+}
+
+func (n *notifier) configured() bool {
+	return len(n.opts.webhooks) > 0 || len(n.opts.execs) > 0 || n.opts.bell
+}
+
+func (n *notifier) wants(trigger string) bool {
+	return n.configured() && slices.Contains(n.opts.on, trigger)
+}
+
+// observe sends a notification for the first failed resource of each
+// operation of a stack. Events must be observed oldest first.
+func (n *notifier) observe(ctx context.Context, stackName string, e types.StackEvent) {
+	n.mu.Lock()
+	if isStackEvent(e) {
+		if operationStartStatuses[e.ResourceStatus] {
+			delete(n.failed, stackName)
+		}
+		n.mu.Unlock()
+		return
+	}
+	status := string(e.ResourceStatus)
+	if !strings.HasSuffix(status, "_FAILED") || n.failed[stackName] || !n.wants(triggerFailure) {
+		n.mu.Unlock()
+		return
+	}
+	n.failed[stackName] = true
+	n.mu.Unlock()
+	n.send(ctx, notification{
+		Text: fmt.Sprintf("Stack %s: %s (%s) %s: %s",
+			stackName, getValue(e.LogicalResourceId), getValue(e.ResourceType), status, getValue(e.ResourceStatusReason)),
+		Trigger:      triggerFailure,
+		Stack:        stackName,
+		Status:       status,
+		LogicalID:    getValue(e.LogicalResourceId),
+		ResourceType: getValue(e.ResourceType),
+		Reason:       getValue(e.ResourceStatusReason),
+		Timestamp:    eventTime(e),
+	})
+}
+
+// stackStatus notifies that a stack reached a terminal status.
+func (n *notifier) stackStatus(ctx context.Context, stackName string, status types.StackStatus, reason string) {
+	if !n.wants(triggerStatus) {
+		return
+	}
+	text := fmt.Sprintf("Stack %s finished: %s", stackName, status)
+	if reason != "" {
+		text += " (" + reason + ")"
+	}
+	n.send(ctx, notification{
+		Text:      text,
+		Trigger:   triggerStatus,
+		Stack:     stackName,
+		Status:    string(status),
+		Reason:    reason,
+		Timestamp: time.Now(),
+	})
+}
+
+// stuck notifies that a resource has been in progress for too long.
+func (n *notifier) stuck(ctx context.Context, stackName string, r *resourceProgress, d time.Duration) {
+	if !n.wants(triggerStuck) {
+		return
+	}
+	n.send(ctx, notification{
+		Text: fmt.Sprintf("Stack %s: %s (%s) has been %s for %s",
+			stackName, r.logicalID, r.resourceType, r.status, d.Round(time.Second)),
+		Trigger:      triggerStuck,
+		Stack:        stackName,
+		Status:       string(r.status),
+		LogicalID:    r.logicalID,
+		ResourceType: r.resourceType,
+		Timestamp:    time.Now(),
+	})
+}
+
+// stuckResources notifies once for each resource of p in progress for longer
+// than threshold.
+func (n *notifier) stuckResources(ctx context.Context, stackName string, p *progressTracker, threshold time.Duration) {
+	if !n.wants(triggerStuck) {
+		return
+	}
+	now := time.Now()
+	for _, r := range p.stuck(now, threshold) {
+		if r.notified {
+			continue
+		}
+		r.notified = true
+		n.stuck(ctx, stackName, r, now.Sub(r.since))
+	}
+}
+
+// finished notifies about a stack whose operation is already over: the first
+// failed resource of its latest operation, then its status. events are
+// newest first.
+func (n *notifier) finished(ctx context.Context, stackName string, status types.StackStatus, reason string, events []types.StackEvent) {
+	if ops := splitOperations(events); len(ops) > 0 {
+		for i := len(ops[0]) - 1; i >= 0; i-- {
+			n.observe(ctx, stackName, ops[0][i])
+		}
+	}
+	n.stackStatus(ctx, stackName, status, reason)
+}
+
+// deleteWatcher follows the events of a stack being deleted, to send the
+// failure and stuck notifications that tail sends. A nil watcher does
+// nothing.
+type deleteWatcher struct {
+	notifier   *notifier
+	client     *cloudformation.Client
+	stuckAfter time.Duration
+	target     *tailTarget // the stack is followed by ID once it is deleted
+}
+
+// newDeleteWatcher returns a watcher for a stack about to be deleted, or nil
+// if no failure or stuck notification is wanted. Resources deleting for longer
+// than stuckAfter are reported as stuck.
+func newDeleteWatcher(ctx context.Context, client *cloudformation.Client, n *notifier, stackName string, stuckAfter time.Duration) *deleteWatcher {
+	if !n.wants(triggerFailure) && !(n.wants(triggerStuck) && stuckAfter > 0) {
+		return nil
+	}
+	stack, err := describeStack(ctx, client, stackName)
+	if err == nil {
+		w := &deleteWatcher{
+			notifier:   n,
+			client:     client,
+			stuckAfter: stuckAfter,
+			target: &tailTarget{
+				name:     stackName,
+				ref:      getValue(stack.StackId),
+				seen:     make(map[string]struct{}),
+				progress: newProgressTracker(),
+			},
+		}
+		// Start after the newest event, by CloudFormation's clock
+		if err = w.seed(ctx); err == nil {
+			return w
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warning: failure and stuck notifications disabled for %s: %v\n", stackName, err)
+	return nil
+}
+
+func (w *deleteWatcher) seed(ctx context.Context) error {
+	events, err := listEvents(ctx, w.client, w.target.ref, 1)
+	if err != nil || len(events) == 0 || events[0].Timestamp == nil {
+		return err
+	}
+	w.target.since = *events[0].Timestamp
+	if id := getValue(events[0].EventId); id != "" {
+		w.target.seen[id] = struct{}{}
+	}
+	return nil
+}
+
+// poll notifies about the events since the last poll and the resources
+// stuck deleting.
+func (w *deleteWatcher) poll(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	events, err := listEventsSince(ctx, w.client, w.target.ref, w.target.since)
+	if err != nil {
+		return
+	}
+	for _, e := range w.target.unseen(events) {
+		w.target.progress.observe(e)
+		w.notifier.observe(ctx, w.target.name, e)
+	}
+	w.notifier.stuckResources(ctx, w.target.name, w.target.progress, w.stuckAfter)
+}
+
+func (n *notifier) send(ctx context.Context, note notification) {
+	if n.opts.bell {
+		fmt.Fprint(os.Stderr, "\a")
+	}
+	for _, url := range n.opts.webhooks {
+		if err := n.post(ctx, url, note); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: notification webhook failed: %v\n", err)
+		}
+	}
+	for _, command := range n.opts.execs {
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Env = append(os.Environ(), note.env()...)
+		c.Stdout = os.Stderr
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: notification command %q failed: %v\n", command, err)
+		}
+	}
+}
+
+func (n *notifier) post(ctx context.Context, url string, note notification) error {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+func eventTime(e types.StackEvent) time.Time {
+	if e.Timestamp != nil {
+		return *e.Timestamp
+	}
+	return time.Now()
+}
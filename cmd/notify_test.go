@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// webhookRecorder is a local HTTP server that records the notifications
+// posted to it.
+type webhookRecorder struct {
+	*httptest.Server
+	mu    sync.Mutex
+	notes []notification
+}
+
+func newWebhookRecorder(t *testing.T) *webhookRecorder {
+	r := &webhookRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var n notification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Errorf("invalid webhook payload: %v", err)
+		}
+		r.mu.Lock()
+		r.notes = append(r.notes, n)
+		r.mu.Unlock()
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookRecorder) received() []notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]notification(nil), r.notes...)
+}
+
+func TestNotifierWebhookFirstFailure(t *testing.T) {
+	hook := newWebhookRecorder(t)
+	n := newNotifier(notifyOptions{webhooks: []string{hook.URL}, on: allTriggers})
+	ctx := context.Background()
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	n.observe(ctx, "my-stack", stackEvent(types.ResourceStatusUpdateInProgress, t0))
+	n.observe(ctx, "my-stack", resourceEvent("Bucket", types.ResourceStatusUpdateFailed, "Access Denied", t0.Add(time.Minute)))
+	n.observe(ctx, "my-stack", resourceEvent("Queue", types.ResourceStatusUpdateFailed, "cancelled", t0.Add(2*time.Minute)))
+
+	notes := hook.received()
+	if len(notes) != 1 {
+		t.Fatalf("got %d notifications, want 1 (first failure only)", len(notes))
+	}
+	got := notes[0]
+	if got.Trigger != triggerFailure || got.Stack != "my-stack" || got.LogicalID != "Bucket" || got.Reason != "Access Denied" {
+		t.Errorf("unexpected notification %+v", got)
+	}
+	if !strings.Contains(got.Text, "Bucket") {
+		t.Errorf("text %q should mention the failed resource", got.Text)
+	}
+
+	// A new operation re-arms the failure trigger.
+	n.observe(ctx, "my-stack", stackEvent(types.ResourceStatusUpdateInProgress, t0.Add(time.Hour)))
+	n.observe(ctx, "my-stack", resourceEvent("Bucket", types.ResourceStatusUpdateFailed, "again", t0.Add(61*time.Minute)))
+	if len(hook.received()) != 2 {
+		t.Errorf("expected a failure notification for the new operation")
+	}
+}
+
+func TestNotifierStackStatus(t *testing.T) {
+	hook := newWebhookRecorder(t)
+	n := newNotifier(notifyOptions{webhooks: []string{hook.URL}, on: []string{triggerStatus}})
+	ctx := context.Background()
+
+	n.stackStatus(ctx, "my-stack", types.StackStatusDeleteFailed, "resource in use")
+	n.observe(ctx, "my-stack", resourceEvent("Bucket", types.ResourceStatusDeleteFailed, "in use", time.Now()))
+
+	notes := hook.received()
+	if len(notes) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notes))
+	}
+	if notes[0].Status != "DELETE_FAILED" || notes[0].Reason != "resource in use" {
+		t.Errorf("unexpected notification %+v", notes[0])
+	}
+}
+
+func TestNotifierExec(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	n := newNotifier(notifyOptions{
+		execs: []string{`printf '%s %s %s' "$CFN_TRIGGER" "$CFN_STACK" "$CFN_STATUS" > ` + out},
+		on:    allTriggers,
+	})
+
+	n.stackStatus(context.Background(), "my-stack", types.StackStatusUpdateComplete, "")
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("command did not run: %v", err)
+	}
+	if got, want := string(data), "status my-stack UPDATE_COMPLETE"; got != want {
+		t.Errorf("command saw %q, want %q", got, want)
+	}
+}
+
+func TestNotifyOptionsValidate(t *testing.T) {
+	if err := (notifyOptions{on: allTriggers}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (notifyOptions{on: []string{"finished"}}).validate(); err == nil {
+		t.Error("expected an error for an unknown trigger")
+	}
+}
+
+func TestNotifierFinished(t *testing.T) {
+	hook := newWebhookRecorder(t)
+	n := newNotifier(notifyOptions{webhooks: []string{hook.URL}, on: allTriggers})
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	// Newest first: a failed update after a failed create. Only the failure of
+	// the latest operation is reported.
+	events := []types.StackEvent{
+		stackEvent(types.ResourceStatusUpdateRollbackComplete, t0.Add(3*time.Minute)),
+		resourceEvent("Queue", types.ResourceStatusUpdateFailed, "denied", t0.Add(time.Minute)),
+		stackEvent(types.ResourceStatusUpdateInProgress, t0),
+		resourceEvent("Bucket", types.ResourceStatusCreateFailed, "exists", t0.Add(-time.Hour)),
+		stackEvent(types.ResourceStatusCreateInProgress, t0.Add(-2*time.Hour)),
+	}
+	n.finished(context.Background(), "my-stack", types.StackStatusUpdateRollbackComplete, "", events)
+
+	notes := hook.received()
+	if len(notes) != 2 {
+		t.Fatalf("got %d notifications, want 2: %+v", len(notes), notes)
+	}
+	if notes[0].Trigger != triggerFailure || notes[0].LogicalID != "Queue" {
+		t.Errorf("first notification = %+v, want failure of Queue", notes[0])
+	}
+	if notes[1].Trigger != triggerStatus || notes[1].Status != "UPDATE_ROLLBACK_COMPLETE" {
+		t.Errorf("second notification = %+v, want status", notes[1])
+	}
+}
+
+func TestNotifierStuckResources(t *testing.T) {
+	hook := newWebhookRecorder(t)
+	n := newNotifier(notifyOptions{webhooks: []string{hook.URL}, on: []string{triggerStuck}})
+	p := newProgressTracker()
+	p.observe(resourceEvent("Bucket", types.ResourceStatusDeleteInProgress, "", time.Now().Add(-time.Hour)))
+	p.observe(resourceEvent("Queue", types.ResourceStatusDeleteInProgress, "", time.Now()))
+
+	n.stuckResources(context.Background(), "my-stack", p, defaultStuckAfter)
+	n.stuckResources(context.Background(), "my-stack", p, defaultStuckAfter)
+
+	notes := hook.received()
+	if len(notes) != 1 || notes[0].LogicalID != "Bucket" || notes[0].Status != "DELETE_IN_PROGRESS" {
+		t.Errorf("notifications = %+v, want one for Bucket", notes)
+	}
+}
+
+func TestDeleteWatcherDisabled(t *testing.T) {
+	n := newNotifier(notifyOptions{bell: true, on: []string{triggerStatus}})
+	w := newDeleteWatcher(context.Background(), nil, n, "my-stack", defaultStuckAfter)
+	if w != nil {
+		t.Fatal("watcher created without failure or stuck trigger")
+	}
+	w.poll(context.Background())
+
+	stuckOnly := newNotifier(notifyOptions{bell: true, on: []string{triggerStuck}})
+	if newDeleteWatcher(context.Background(), nil, stuckOnly, "my-stack", 0) != nil {
+		t.Fatal("watcher created for stuck notifications disabled by --stuck-after 0")
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicecatalog"
+	sctypes "github.com/aws/aws-sdk-go-v2/service/servicecatalog/types"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// scStackOutput is the record output holding the stack of a provisioned
+// product.
+const scStackOutput = "CloudformationStackArn"
+
+// serviceCatalogAPI is the part of the Service Catalog client used here.
+type serviceCatalogAPI interface {
+	DescribeProvisionedProduct(ctx context.Context, params *servicecatalog.DescribeProvisionedProductInput, optFns ...func(*servicecatalog.Options)) (*servicecatalog.DescribeProvisionedProductOutput, error)
+	DescribeRecord(ctx context.Context, params *servicecatalog.DescribeRecordInput, optFns ...func(*servicecatalog.Options)) (*servicecatalog.DescribeRecordOutput, error)
+	SearchProvisionedProducts(ctx context.Context, params *servicecatalog.SearchProvisionedProductsInput, optFns ...func(*servicecatalog.Options)) (*servicecatalog.SearchProvisionedProductsOutput, error)
+}
+
+func ScCmd() *cobra.Command {
+	var match string
+
+	cmd := &cobra.Command{
+		Use:   "sc",
+		Short: "List Service Catalog provisioned products and their stacks",
+		Long: `List the Service Catalog provisioned products of the account with the
+CloudFormation stack backing each one and the status of both.
+
+Examples:
+  cfn sc
+  cfn sc --match 'orch-*'`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSc(match)
+		},
+	}
+
+	cmd.Flags().StringVarP(&match, "match", "m", "", "Only show provisioned products whose name matches this glob pattern")
+
+	return cmd
+}
+
+func runSc(match string) {
+	ctx := context.Background()
+	client := mustClient(ctx)
+	sc := mustServiceCatalogClient(ctx)
+
+	products, err := listProvisionedProducts(ctx, sc)
+	if err != nil {
+		fatalf("failed to list provisioned products: %v\n", err)
+	}
+
+	table := makeTable([]string{"NAME", "ID", "PRODUCT", "STATUS", "STACK", "STACK STATUS"})
+	for _, p := range products {
+		if !matchStackName(getValue(p.Name), match, false) {
+			continue
+		}
+		stack, stackStatus := "", ""
+		if arn, err := provisionedProductStackARN(ctx, sc, p); err == nil {
+			stack = stackNameFromARN(arn)
+			stackStatus = "NOT FOUND"
+			if s, err := describeStack(ctx, client, arn); err == nil {
+				stackStatus = string(s.StackStatus)
+			}
+		}
+		table.Rows = append(table.Rows, v1.TableRow{
+			Cells: []interface{}{
+				getValue(p.Name),
+				getValue(p.Id),
+				getValue(p.ProductName),
+				string(p.Status),
+				stack,
+				stackStatus,
+			},
+		})
+	}
+	if len(table.Rows) == 0 {
+		fmt.Println("No provisioned products found")
+		return
+	}
+	mustPrint(table)
+}
+
+// listProvisionedProducts returns every provisioned product of the account.
+func listProvisionedProducts(ctx context.Context, sc serviceCatalogAPI) ([]sctypes.ProvisionedProductAttribute, error) {
+	var products []sctypes.ProvisionedProductAttribute
+	input := &servicecatalog.SearchProvisionedProductsInput{
+		AccessLevelFilter: &sctypes.AccessLevelFilter{
+			Key:   sctypes.AccessLevelFilterKeyAccount,
+			Value: aws.String("self"),
+		},
+	}
+	for {
+		out, err := sc.SearchProvisionedProducts(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, out.ProvisionedProducts...)
+		if getValue(out.NextPageToken) == "" {
+			return products, nil
+		}
+		input.PageToken = out.NextPageToken
+	}
+}
+
+// provisionedProductStackARN returns the stack of a provisioned product from
+// search results, whose physical ID is the stack ARN for CloudFormation
+// products, or from its records otherwise.
+func provisionedProductStackARN(ctx context.Context, sc serviceCatalogAPI, p sctypes.ProvisionedProductAttribute) (string, error) {
+	if pid := getValue(p.PhysicalId); strings.Contains(pid, ":cloudformation:") && strings.Contains(pid, ":stack/") {
+		return pid, nil
+	}
+	return scStackARN(ctx, sc, getValue(p.Id))
+}
+
+// scStackARN returns the ARN of the stack backing a provisioned product, read
+// from the outputs of its most recent record that has one.
+func scStackARN(ctx context.Context, sc serviceCatalogAPI, ppID string) (string, error) {
+	out, err := sc.DescribeProvisionedProduct(ctx, &servicecatalog.DescribeProvisionedProductInput{Id: &ppID})
+	if err != nil {
+		return "", err
+	}
+	detail := out.ProvisionedProductDetail
+	if detail == nil {
+		return "", fmt.Errorf("provisioned product %s not found", ppID)
+	}
+
+	var seen []string
+	for _, id := range []*string{detail.LastRecordId, detail.LastProvisioningRecordId, detail.LastSuccessfulProvisioningRecordId} {
+		recordID := getValue(id)
+		if recordID == "" || slices.Contains(seen, recordID) {
+			continue
+		}
+		seen = append(seen, recordID)
+		arn, err := recordStackARN(ctx, sc, recordID)
+		if err != nil {
+			return "", err
+		}
+		if arn != "" {
+			return arn, nil
+		}
+	}
+	return "", fmt.Errorf("no CloudFormation stack recorded for provisioned product %s", ppID)
+}
+
+// recordStackARN returns the stack ARN output of a record, or "" if it has
+// none.
+func recordStackARN(ctx context.Context, sc serviceCatalogAPI, recordID string) (string, error) {
+	input := &servicecatalog.DescribeRecordInput{Id: &recordID}
+	for {
+		out, err := sc.DescribeRecord(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("failed to describe record %s: %w", recordID, err)
+		}
+		for _, o := range out.RecordOutputs {
+			if strings.EqualFold(getValue(o.OutputKey), scStackOutput) {
+				return getValue(o.OutputValue), nil
+			}
+		}
+		if getValue(out.NextPageToken) == "" {
+			return "", nil
+		}
+		input.PageToken = out.NextPageToken
+	}
+}
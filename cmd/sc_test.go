@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicecatalog"
+	sctypes "github.com/aws/aws-sdk-go-v2/service/servicecatalog/types"
+)
+
+// fakeServiceCatalog serves provisioned products and records from memory.
+// Records are returned one output per page to exercise pagination.
+type fakeServiceCatalog struct {
+	products map[string]sctypes.ProvisionedProductDetail
+	records  map[string][]sctypes.RecordOutput
+	search   [][]sctypes.ProvisionedProductAttribute
+}
+
+func (f *fakeServiceCatalog) DescribeProvisionedProduct(ctx context.Context, params *servicecatalog.DescribeProvisionedProductInput, optFns ...func(*servicecatalog.Options)) (*servicecatalog.DescribeProvisionedProductOutput, error) {
+	detail, ok := f.products[aws.ToString(params.Id)]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFoundException")
+	}
+	return &servicecatalog.DescribeProvisionedProductOutput{ProvisionedProductDetail: &detail}, nil
+}
+
+func (f *fakeServiceCatalog) DescribeRecord(ctx context.Context, params *servicecatalog.DescribeRecordInput, optFns ...func(*servicecatalog.Options)) (*servicecatalog.DescribeRecordOutput, error) {
+	outputs, ok := f.records[aws.ToString(params.Id)]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFoundException")
+	}
+	page := 0
+	fmt.Sscan(aws.ToString(params.PageToken), &page)
+	out := &servicecatalog.DescribeRecordOutput{}
+	if page < len(outputs) {
+		out.RecordOutputs = outputs[page : page+1]
+	}
+	if page+1 < len(outputs) {
+		out.NextPageToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return out, nil
+}
+
+func (f *fakeServiceCatalog) SearchProvisionedProducts(ctx context.Context, params *servicecatalog.SearchProvisionedProductsInput, optFns ...func(*servicecatalog.Options)) (*servicecatalog.SearchProvisionedProductsOutput, error) {
+	page := 0
+	fmt.Sscan(aws.ToString(params.PageToken), &page)
+	out := &servicecatalog.SearchProvisionedProductsOutput{ProvisionedProducts: f.search[page]}
+	if page+1 < len(f.search) {
+		out.NextPageToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return out, nil
+}
+
+const testSCStackARN = "arn:aws:cloudformation:us-east-1:123456789012:stack/SC-123456789012-pp-abc/0a1b2c3d"
+
+func testServiceCatalog() *fakeServiceCatalog {
+	return &fakeServiceCatalog{
+		products: map[string]sctypes.ProvisionedProductDetail{
+			"pp-abc": {Id: aws.String("pp-abc"), LastRecordId: aws.String("rec-update"), LastSuccessfulProvisioningRecordId: aws.String("rec-create")},
+			"pp-new": {Id: aws.String("pp-new"), LastRecordId: aws.String("rec-failed")},
+		},
+		records: map[string][]sctypes.RecordOutput{
+			// A failed update records no outputs; the stack is in the create record
+			"rec-update": {},
+			"rec-create": {
+				{OutputKey: aws.String("BucketName"), OutputValue: aws.String("my-bucket")},
+				{OutputKey: aws.String("CloudformationStackARN"), OutputValue: aws.String(testSCStackARN)},
+			},
+			"rec-failed": {},
+		},
+		search: [][]sctypes.ProvisionedProductAttribute{
+			{{Id: aws.String("pp-abc"), PhysicalId: aws.String(testSCStackARN)}},
+			{{Id: aws.String("pp-other"), PhysicalId: aws.String("i-0123456789")}},
+		},
+	}
+}
+
+func TestScStackARN(t *testing.T) {
+	sc := testServiceCatalog()
+	tests := []struct {
+		ppID    string
+		want    string
+		wantErr bool
+	}{
+		{"pp-abc", testSCStackARN, false},
+		{"pp-new", "", true},
+		{"pp-missing", "", true},
+	}
+	for _, tt := range tests {
+		got, err := scStackARN(context.Background(), sc, tt.ppID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("scStackARN(%s) error = %v, wantErr %v", tt.ppID, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("scStackARN(%s) = %q, want %q", tt.ppID, got, tt.want)
+		}
+	}
+	if name := stackNameFromARN(testSCStackARN); name != "SC-123456789012-pp-abc" {
+		t.Errorf("stack name = %q", name)
+	}
+}
+
+func TestListProvisionedProducts(t *testing.T) {
+	sc := testServiceCatalog()
+	products, err := listProvisionedProducts(context.Background(), sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 2 {
+		t.Fatalf("products = %d, want 2", len(products))
+	}
+	arn, err := provisionedProductStackARN(context.Background(), sc, products[0])
+	if err != nil || arn != testSCStackARN {
+		t.Errorf("stack of %s = %q, %v", aws.ToString(products[0].Id), arn, err)
+	}
+	// Not a stack ARN, so the records are looked up, and pp-other has none
+	if _, err := provisionedProductStackARN(context.Background(), sc, products[1]); err == nil {
+		t.Errorf("stack of pp-other found, want error")
+	}
+}
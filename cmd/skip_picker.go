@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// skipCandidate is a resource that can be passed to ContinueUpdateRollback in
+// ResourcesToSkip.
+type skipCandidate struct {
+	id           string // logical ID, NestedStack.LogicalId for nested stacks
+	resourceType string
+	physicalID   string
+	reason       string
+}
+
+func newSkipCandidate(prefix string, r types.StackResourceSummary) skipCandidate {
+	return skipCandidate{
+		id:           prefix + getValue(r.LogicalResourceId),
+		resourceType: getValue(r.ResourceType),
+		physicalID:   getValue(r.PhysicalResourceId),
+		reason:       getValue(r.ResourceStatusReason),
+	}
+}
+
+// skipWarning returns what skipping the rollback of a resource means for its
+// data, or "" for stateless types.
+func skipWarning(resourceType string) string {
+	desc, ok := statefulTypes[resourceType]
+	switch resourceType {
+	case "AWS::S3::Bucket":
+		desc, ok = "S3 bucket", true
+	case "AWS::DynamoDB::Table":
+		desc, ok = "DynamoDB table", true
+	}
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s: it keeps its updated configuration and data while the stack records the previous state; the next update may modify or replace it", desc)
+}
+
+// stdinIsTTY reports whether input can be read from a terminal.
+func stdinIsTTY() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return (fi.Mode() & os.ModeCharDevice) != 0
+}
+
+// pickSkips lets the user choose which failed resources to skip. Pressing
+// enter selects all of them if skipAll is set, none otherwise. Picking
+// stateful resources asks for confirmation first.
+func pickSkips(candidates []skipCandidate, skipAll bool, in io.Reader, out io.Writer) ([]string, error) {
+	fmt.Fprintf(out, "Resources in UPDATE_FAILED state:\n")
+	for i, c := range candidates {
+		fmt.Fprintf(out, "  %d. %s (%s)\n", i+1, c.id, c.resourceType)
+		if c.physicalID != "" {
+			fmt.Fprintf(out, "       physical ID: %s\n", c.physicalID)
+		}
+		if c.reason != "" {
+			fmt.Fprintf(out, "       reason:      %s\n", c.reason)
+		}
+	}
+	fmt.Fprintln(out)
+
+	def := "none"
+	if skipAll {
+		def = "all"
+	}
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Resources to skip (e.g. 1,3-4, all, none) [%s]: ", def)
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		input := strings.TrimSpace(line)
+		if input == "" {
+			input = def
+		}
+		picked, perr := parseSelection(input, len(candidates))
+		if perr != nil {
+			fmt.Fprintf(out, "  %v\n", perr)
+			if err != nil {
+				return nil, perr
+			}
+			continue
+		}
+
+		var skip, warnings []string
+		for _, i := range picked {
+			c := candidates[i]
+			skip = append(skip, c.id)
+			if w := skipWarning(c.resourceType); w != "" {
+				warnings = append(warnings, fmt.Sprintf("  %s is a %s", c.id, w))
+			}
+		}
+		if len(warnings) == 0 {
+			return skip, nil
+		}
+
+		fmt.Fprintf(out, "\nwarning: skipping stateful resources:\n%s\n", strings.Join(warnings, "\n"))
+		fmt.Fprintf(out, "Skip them anyway? Type 'yes' to confirm: ")
+		answer, aerr := reader.ReadString('\n')
+		if strings.EqualFold(strings.TrimSpace(answer), "yes") {
+			return skip, nil
+		}
+		if aerr != nil {
+			return nil, fmt.Errorf("aborted")
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// parseSelection parses a list of 1-based item numbers and ranges, "all" or
+// "none", and returns the sorted 0-based indexes.
+func parseSelection(input string, n int) ([]int, error) {
+	switch strings.ToLower(input) {
+	case "all":
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		return all, nil
+	case "none":
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' }) {
+		lo, hi, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", field)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid range %q", field)
+			}
+		}
+		if first < 1 || last > n {
+			return nil, fmt.Errorf("%q is out of range 1-%d", field, n)
+		}
+		for i := first; i <= last; i++ {
+			seen[i-1] = true
+		}
+	}
+
+	picked := make([]int, 0, len(seen))
+	for i := range seen {
+		picked = append(picked, i)
+	}
+	sort.Ints(picked)
+	return picked, nil
+}
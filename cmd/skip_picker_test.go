@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		{"all", []int{0, 1, 2, 3}, false},
+		{"none", nil, false},
+		{"2", []int{1}, false},
+		{"1,3", []int{0, 2}, false},
+		{"3 1", []int{0, 2}, false},
+		{"2-4,2", []int{1, 2, 3}, false},
+		{"0", nil, true},
+		{"5", nil, true},
+		{"3-2", nil, true},
+		{"x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSelection(tt.input, 4)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSelection(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && len(got)+len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelection(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPickSkips(t *testing.T) {
+	candidates := []skipCandidate{
+		{id: "Role", resourceType: "AWS::IAM::Role", reason: "Access denied"},
+		{id: "Network.Db", resourceType: "AWS::RDS::DBInstance", physicalID: "db-1"},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		skipAll bool
+		want    []string
+		wantErr bool
+	}{
+		{"default none", "\n", false, nil, false},
+		{"default all, confirmed", "\nyes\n", true, []string{"Role", "Network.Db"}, false},
+		{"stateful declined, repicked", "2\nno\n1\n", false, []string{"Role"}, false},
+		{"invalid, repicked", "7\n1\n", false, []string{"Role"}, false},
+		{"stateful declined at eof", "2\n", false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickSkips(candidates, tt.skipAll, strings.NewReader(tt.input), io.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("skip = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipWarning(t *testing.T) {
+	for _, typ := range []string{"AWS::RDS::DBInstance", "AWS::S3::Bucket", "AWS::DynamoDB::Table"} {
+		if skipWarning(typ) == "" {
+			t.Errorf("skipWarning(%s) is empty", typ)
+		}
+	}
+	if w := skipWarning("AWS::IAM::Role"); w != "" {
+		t.Errorf("skipWarning(AWS::IAM::Role) = %q", w)
+	}
+}
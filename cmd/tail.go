@@ -4,135 +4,745 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/spf13/cobra"
 )
 
+type tailOptions struct {
+	interval      time.Duration
+	untilComplete bool
+	recursive     bool
+	match         string
+	filters       stackFilters
+	rate          int
+	progress      bool
+	stuckAfter    time.Duration
+	output        string
+	notify        notifyOptions
+}
+
+// info returns where progress messages go. With JSON Lines output stdout is
+// reserved for events.
+func (o tailOptions) info() io.Writer {
+	if o.output == "jsonl" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// matching reports whether stacks are selected by pattern and filters rather
+// than by name.
+func (o tailOptions) matching() bool {
+	return o.match != "" || o.filters.isSet()
+}
+
 func TailCmd() *cobra.Command {
 	var interval int
+	var opts tailOptions
 
 	cmd := &cobra.Command{
-		Use:   "tail <stack-name>",
+		Use:   "tail [stack-name]",
 		Short: "Stream stack events in real time (Ctrl-C to stop)",
-		Args:  cobra.ExactArgs(1),
+		Long: `Stream stack events in real time (Ctrl-C to stop).
+
+With --until-complete the command exits once the stack reaches a terminal
+status and prints a summary of the operation. The exit code is 0 when the
+operation succeeded and 1 for rollbacks and failures, which makes it usable
+as a CI step right after starting a deployment.
+
+With --recursive, nested stacks (AWS::CloudFormation::Stack) and the stacks
+behind Service Catalog provisioned products are followed automatically as
+they start updating, and their events are merged into a single stream with a
+STACK column indented by nesting depth. Children stop being followed once
+they reach a terminal status.
+
+Instead of a stack name, --match selects every stack whose name matches a
+glob pattern, narrowed by the same filters as 'cfn list'. All matching
+stacks are polled concurrently (limited by --rate) and their events are
+merged into one chronological stream prefixed with a colored stack name.
+Stacks that start matching later, such as newly created ones, are picked up
+automatically.
+
+On an interactive terminal a single-stack tail shows a live footer with the
+number of complete, in-progress and failed resources out of the template's
+resource count, and the elapsed time next to the duration of the previous
+successful deployment. Resources in progress for longer than --stuck-after
+(often custom resources or ECS services waiting on stabilization) are
+highlighted in the footer, or reported once as warnings when there is no
+footer.
+
+With --output jsonl every event is written as one JSON object per line with
+all of its fields, for log shipping and CI parsing. Everything else goes to
+stderr.
+
+Notifications can be sent when a stack reaches a terminal status, on the
+first failed resource of an operation, and when a resource is stuck: POST a
+Slack-compatible JSON payload with --notify-webhook, run a command with
+--notify-exec (details in CFN_STACK, CFN_STATUS, CFN_REASON and other CFN_*
+variables), or ring the terminal bell with --notify-bell.
+
+Examples:
+  cfn tail my-stack
+  cfn tail my-stack --until-complete
+  cfn tail my-stack --recursive
+  cfn tail --match 'orch-b-*'
+  cfn tail --match 'orch-b-*' --in-progress --desc production
+  cfn tail my-stack --output jsonl
+  cfn tail my-stack --until-complete --notify-webhook https://hooks.slack.com/services/...`,
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			runTail(args[0], time.Duration(interval)*time.Second)
+			opts.interval = time.Duration(interval) * time.Second
+			if len(args) == 1 && opts.matching() {
+				fatalf("a stack name cannot be combined with --match or stack filters\n")
+			}
+			if len(args) == 0 && !opts.matching() {
+				fatalf("requires a stack name or --match\n")
+			}
+			if opts.output != "table" && opts.output != "jsonl" {
+				fatalf("invalid --output %q (expected table or jsonl)\n", opts.output)
+			}
+			if err := opts.notify.validate(); err != nil {
+				fatalf("%v\n", err)
+			}
+			if opts.untilComplete && opts.matching() {
+				fatalf("--until-complete requires a single stack name\n")
+			}
+			stackName := ""
+			if len(args) == 1 {
+				stackName = args[0]
+			}
+			runTail(stackName, opts)
 		},
 	}
 
 	cmd.Flags().IntVarP(&interval, "interval", "s", 5, "Polling interval in seconds")
+	cmd.Flags().BoolVar(&opts.untilComplete, "until-complete", false, "Exit when the stack reaches a terminal status (non-zero on rollback or failure)")
+	cmd.Flags().BoolVar(&opts.recursive, "recursive", false, "Also follow nested stacks and Service Catalog product stacks")
+	cmd.Flags().StringVarP(&opts.match, "match", "m", "", "Follow every stack whose name matches this glob pattern (e.g. 'orch-b-*')")
+	cmd.Flags().IntVar(&opts.rate, "rate", 5, "Maximum CloudFormation API calls per second while polling")
+	cmd.Flags().BoolVar(&opts.progress, "progress", true, "Show a live progress footer (interactive terminals, single stack only)")
+	cmd.Flags().DurationVar(&opts.stuckAfter, "stuck-after", defaultStuckAfter, "Highlight resources in progress for longer than this (0 disables)")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format: table or jsonl")
+	opts.filters.register(cmd)
+	opts.notify.register(cmd)
 
 	return cmd
 }
 
-func runTail(stackName string, interval time.Duration) {
+func runTail(stackName string, opts tailOptions) {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	client := mustClient(ctx)
+	session := newTailSession(client, opts)
+	if opts.recursive {
+		session.sc = mustServiceCatalogClient(ctx)
+	}
 
-	// Seed: remember the timestamp of the most recent event so we only show new ones.
-	var since time.Time
-	var initialEvent *types.StackEvent
-	seenEventIDs := make(map[string]struct{})
-	{
-		events, err := listEvents(ctx, client, stackName, 1)
+	// With --until-complete, follow the stack by ID so that a stack which is
+	// deleted while we watch can still be queried for its final events.
+	stackRef := stackName
+	if opts.untilComplete {
+		stack, err := describeStack(ctx, client, stackName)
 		if err != nil {
-			fatalf("failed to get initial events: %v\n", err)
+			fatalf("failed to describe stack %q: %v\n", stackName, err)
 		}
-		if len(events) > 0 && events[0].Timestamp != nil {
-			initialEvent = &events[0]
-			since = *events[0].Timestamp
-			if id := getValue(events[0].EventId); id != "" {
-				seenEventIDs[id] = struct{}{}
+		stackRef = getValue(stack.StackId)
+		if isTerminalStackStatus(stack.StackStatus) {
+			events, err := listEvents(ctx, client, stackRef, 0)
+			if err != nil {
+				fatalf("failed to list events for stack %q: %v\n", stackName, err)
 			}
+			session.notifier.finished(ctx, stackName, stack.StackStatus, getValue(stack.StackStatusReason), events)
+			finishTail(opts.info(), stackName, stack.StackStatus, events)
+			return
 		}
 	}
 
-	fmt.Printf("Tailing events for stack %q (Ctrl-C to stop)...\n\n", stackName)
-	if !noHeaders {
-		fmt.Printf("%-22s %-40s %-45s %-30s %s\n", "TIMESTAMP", "LOGICAL ID", "TYPE", "STATUS", "REASON")
-		fmt.Printf("%-22s %-40s %-45s %-30s %s\n",
-			"──────────────────────", "────────────────────────────────────────",
-			"─────────────────────────────────────────────", "──────────────────────────────", "──────")
+	var root *tailTarget
+	var initialEvent *types.StackEvent
+	if opts.matching() {
+		stacks, err := matchStacks(ctx, client, opts.match, opts.filters)
+		if err != nil {
+			fatalf("failed to list stacks: %v\n", err)
+		}
+		for _, st := range stacks {
+			t := session.follow(getValue(st.StackName), getValue(st.StackId), 0, time.Time{})
+			if _, err := session.seed(ctx, t); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to get events for %s: %v\n", t.name, err)
+			}
+		}
+		pattern := opts.match
+		if pattern == "" {
+			pattern = "*"
+		}
+		fmt.Fprintf(opts.info(), "Tailing events for %d stack(s) matching %q (Ctrl-C to stop)...\n\n", len(stacks), pattern)
+	} else {
+		root = session.follow(stackName, stackRef, 0, time.Time{})
+
+		// Seed: remember the most recent event so we only show new ones.
+		var err error
+		initialEvent, err = session.seed(ctx, root)
+		if err != nil {
+			fatalf("failed to get initial events: %v\n", err)
+		}
+
+		if opts.untilComplete {
+			fmt.Fprintf(opts.info(), "Tailing events for stack %q until it reaches a terminal status...\n\n", stackName)
+		} else {
+			fmt.Fprintf(opts.info(), "Tailing events for stack %q (Ctrl-C to stop)...\n\n", stackName)
+		}
 	}
+	session.printHeader()
 
 	if initialEvent != nil {
-		ts := initialEvent.Timestamp.Format("2006-01-02 15:04:05")
-		fmt.Printf("%-22s %-40s %-45s %-30s %s\n",
-			ts,
-			truncate(getValue(initialEvent.LogicalResourceId), 40),
-			truncate(getValue(initialEvent.ResourceType), 45),
-			truncate(string(initialEvent.ResourceStatus), 30),
-			getValue(initialEvent.ResourceStatusReason),
-		)
+		session.print(tailEvent{event: *initialEvent, target: root})
 	}
 
-	ticker := time.NewTicker(interval)
+	if opts.recursive {
+		for _, t := range session.targets {
+			session.followActiveChildren(ctx, t)
+		}
+	}
+
+	if root != nil && (opts.progress || opts.stuckAfter > 0) {
+		session.loadProgress(ctx, root)
+	}
+	if session.footerEnabled() {
+		session.drawFooter(root)
+	}
+
+	ticker := time.NewTicker(opts.interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("\nStopped.")
+			session.clearFooter()
+			fmt.Fprintln(opts.info(), "\nStopped.")
 			return
 		case <-ticker.C:
-			events, err := listEvents(ctx, client, stackName, 0)
-			if err != nil {
-				if ctx.Err() != nil || errors.Is(err, context.Canceled) {
-					continue
-				}
-				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
-				continue
+			// Warnings may be printed while polling, so the footer goes first.
+			session.clearFooter()
+			if opts.matching() {
+				session.refresh(ctx)
 			}
 
-			// Events are newest-first; collect those newer than `since`.
-			// Include equal-timestamp events when their EventId hasn't been seen yet.
-			var newEvents []types.StackEvent
-			for _, e := range events {
-				if e.Timestamp == nil {
-					continue
+			reachedTerminal := false
+			for _, te := range session.poll(ctx) {
+				session.print(te)
+				te.target.progress.observe(te.event)
+				session.notifier.observe(ctx, te.target.name, te.event)
+				if isStackEvent(te.event) && isTerminalStackStatus(types.StackStatus(te.event.ResourceStatus)) {
+					if te.target.depth == 0 {
+						session.notifier.stackStatus(ctx, te.target.name, types.StackStatus(te.event.ResourceStatus), getValue(te.event.ResourceStatusReason))
+					}
+					switch {
+					case te.target == root:
+						reachedTerminal = true
+					case te.target.depth > 0, te.event.ResourceStatus == types.ResourceStatusDeleteComplete:
+						te.target.done = true
+					}
 				}
-
-				if e.Timestamp.After(since) {
-					newEvents = append(newEvents, e)
-					continue
+				if opts.recursive {
+					session.discover(te)
 				}
+			}
+			if opts.recursive {
+				session.resolveProducts(ctx)
+			}
 
-				if e.Timestamp.Equal(since) {
-					if id := getValue(e.EventId); id != "" {
-						if _, exists := seenEventIDs[id]; !exists {
-							newEvents = append(newEvents, e)
-						}
+			if root != nil && root.progress.restarted {
+				session.countResources(ctx, root)
+			}
+			if opts.untilComplete && reachedTerminal {
+				// Stack-level events can report transient statuses; trust the stack itself.
+				stack, err := describeStack(ctx, client, stackRef)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+				} else if isTerminalStackStatus(stack.StackStatus) {
+					events, err := listEvents(ctx, client, stackRef, 0)
+					if err != nil {
+						fatalf("failed to list events for stack %q: %v\n", stackName, err)
 					}
+					finishTail(opts.info(), stackName, stack.StackStatus, events)
+					return
 				}
 			}
 
-			for i := len(newEvents) - 1; i >= 0; i-- {
-				e := newEvents[i]
-				if id := getValue(e.EventId); id != "" {
-					seenEventIDs[id] = struct{}{}
-				}
-				ts := ""
-				if e.Timestamp != nil {
-					ts = e.Timestamp.Format("2006-01-02 15:04:05")
-					if e.Timestamp.After(since) {
-						since = *e.Timestamp
-					}
+			session.notifyStuck(ctx)
+			if session.footerEnabled() {
+				session.drawFooter(root)
+			} else {
+				session.warnStuck()
+			}
+		}
+	}
+}
+
+// tailTarget is a single stack followed by tail.
+type tailTarget struct {
+	name  string // display name
+	ref   string // stack name or ID used for API calls
+	depth int    // nesting depth below the stack given on the command line
+	since time.Time
+	seen  map[string]struct{}
+	done  bool
+
+	// started holds when the resources in progress started, so that their
+	// child stacks are followed from before their first event.
+	started map[string]time.Time
+
+	progress *progressTracker
+}
+
+// unseen returns the events that have not been shown yet, oldest first, and
+// records them as shown. events must be ordered newest-first.
+func (t *tailTarget) unseen(events []types.StackEvent) []types.StackEvent {
+	var fresh []types.StackEvent
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.Timestamp == nil || e.Timestamp.Before(t.since) {
+			continue
+		}
+
+		// Include equal-timestamp events when their EventId hasn't been seen yet.
+		id := getValue(e.EventId)
+		if e.Timestamp.Equal(t.since) {
+			if id == "" {
+				continue
+			}
+			if _, exists := t.seen[id]; exists {
+				continue
+			}
+		}
+
+		if id != "" {
+			t.seen[id] = struct{}{}
+		}
+		t.since = *e.Timestamp
+		fresh = append(fresh, e)
+	}
+	return fresh
+}
+
+// tailEvent is an event together with the stack it was read from.
+type tailEvent struct {
+	event  types.StackEvent
+	target *tailTarget
+}
+
+// tailSession tracks every stack followed by a tail invocation.
+type tailSession struct {
+	client   *cloudformation.Client
+	sc       serviceCatalogAPI // set with --recursive
+	opts     tailOptions
+	limiter  *rateLimiter
+	notifier *notifier
+	targets  []*tailTarget
+	byRef    map[string]*tailTarget
+
+	// Service Catalog provisioned products whose stack has not been found
+	// yet, and the stack ARN of those already resolved.
+	pendingProducts  map[string]pendingProduct
+	resolvedProducts map[string]string
+
+	footerLines int // lines of the status footer currently on screen
+}
+
+// pendingProduct records where a provisioned product's stack belongs in the
+// stream until the stack can be found.
+type pendingProduct struct {
+	depth  int
+	since  time.Time
+	warned bool
+}
+
+func newTailSession(client *cloudformation.Client, opts tailOptions) *tailSession {
+	return &tailSession{
+		client:           client,
+		opts:             opts,
+		limiter:          newRateLimiter(opts.rate),
+		notifier:         newNotifier(opts.notify),
+		byRef:            make(map[string]*tailTarget),
+		pendingProducts:  make(map[string]pendingProduct),
+		resolvedProducts: make(map[string]string),
+	}
+}
+
+// follow starts following a stack, showing its events from since onwards. A
+// stack that is already known is reactivated if it had finished.
+func (s *tailSession) follow(name, ref string, depth int, since time.Time) *tailTarget {
+	if t, ok := s.byRef[ref]; ok {
+		if t.done {
+			t.done = false
+			if since.After(t.since) {
+				t.since = since
+			}
+		}
+		return t
+	}
+	t := &tailTarget{
+		name:     name,
+		ref:      ref,
+		depth:    depth,
+		since:    since,
+		seen:     make(map[string]struct{}),
+		started:  make(map[string]time.Time),
+		progress: newProgressTracker(),
+	}
+	s.targets = append(s.targets, t)
+	s.byRef[ref] = t
+	return t
+}
+
+// seed marks the most recent event of t as seen and returns it.
+func (s *tailSession) seed(ctx context.Context, t *tailTarget) (*types.StackEvent, error) {
+	if err := s.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	events, err := listEvents(ctx, s.client, t.ref, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 || events[0].Timestamp == nil {
+		return nil, nil
+	}
+	t.since = *events[0].Timestamp
+	if id := getValue(events[0].EventId); id != "" {
+		t.seen[id] = struct{}{}
+	}
+	return &events[0], nil
+}
+
+// poll fetches new events for every active target concurrently and returns
+// them merged in chronological order.
+func (s *tailSession) poll(ctx context.Context) []tailEvent {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		fresh []tailEvent
+	)
+	for _, t := range s.targets {
+		if t.done {
+			continue
+		}
+		wg.Go(func() {
+			if err := s.limiter.wait(ctx); err != nil {
+				return
+			}
+			events, err := listEventsSince(ctx, s.client, t.ref, t.since)
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+					return
 				}
-				fmt.Printf("%-22s %-40s %-45s %-30s %s\n",
-					ts,
-					truncate(getValue(e.LogicalResourceId), 40),
-					truncate(getValue(e.ResourceType), 45),
-					truncate(string(e.ResourceStatus), 30),
-					getValue(e.ResourceStatusReason),
-				)
+				fmt.Fprintf(os.Stderr, "warning: %s: %v\n", t.name, err)
+				return
 			}
+			unseen := t.unseen(events)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, e := range unseen {
+				fresh = append(fresh, tailEvent{event: e, target: t})
+			}
+		})
+	}
+	wg.Wait()
+
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return fresh[i].event.Timestamp.Before(*fresh[j].event.Timestamp)
+	})
+	return fresh
+}
+
+// refresh starts following stacks that match --match and the filters but are
+// not followed yet. Their events are shown from the start of their current
+// operation, so newly created stacks are shown from creation.
+func (s *tailSession) refresh(ctx context.Context) {
+	if err := s.limiter.wait(ctx); err != nil {
+		return
+	}
+	stacks, err := matchStacks(ctx, s.client, s.opts.match, s.opts.filters)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to list stacks: %v\n", err)
+		}
+		return
+	}
+	for _, st := range stacks {
+		ref := getValue(st.StackId)
+		if _, ok := s.byRef[ref]; ok {
+			continue
 		}
+		s.follow(getValue(st.StackName), ref, 0, stackLastUpdated(st))
+	}
+}
+
+// discover starts following the nested stack or Service Catalog product stack
+// that te reports as starting an operation. The child is followed from the
+// first in-progress event of its resource: the event carrying its physical ID
+// comes after the child stack was created.
+func (s *tailSession) discover(te tailEvent) {
+	e := te.event
+	if isStackEvent(e) {
+		return
 	}
+	logicalID := getValue(e.LogicalResourceId)
+	if !strings.HasSuffix(string(e.ResourceStatus), "_IN_PROGRESS") {
+		delete(te.target.started, logicalID)
+		return
+	}
+	since, ok := te.target.started[logicalID]
+	if !ok {
+		since = *e.Timestamp
+		te.target.started[logicalID] = since
+	}
+	physicalID := getValue(e.PhysicalResourceId)
+	if physicalID == "" {
+		return
+	}
+
+	switch getValue(e.ResourceType) {
+	case "AWS::CloudFormation::Stack":
+		s.follow(stackNameFromARN(physicalID), physicalID, te.target.depth+1, since)
+	case "AWS::ServiceCatalog::CloudFormationProvisionedProduct":
+		if ref, ok := s.resolvedProducts[physicalID]; ok {
+			s.follow(stackNameFromARN(ref), ref, te.target.depth+1, since)
+			return
+		}
+		if _, ok := s.pendingProducts[physicalID]; !ok {
+			s.pendingProducts[physicalID] = pendingProduct{depth: te.target.depth + 1, since: since}
+		}
+	}
+}
+
+// resolveProducts looks up the stacks behind pending Service Catalog
+// provisioned products in their records. Products whose stack is not recorded
+// yet are retried on the next poll.
+func (s *tailSession) resolveProducts(ctx context.Context) {
+	for ppID, pending := range s.pendingProducts {
+		ref, err := scStackARN(ctx, s.sc, ppID)
+		if err != nil {
+			if !pending.warned {
+				fmt.Fprintf(os.Stderr, "warning: failed to find the stack of provisioned product %s (retrying): %v\n", ppID, err)
+				pending.warned = true
+				s.pendingProducts[ppID] = pending
+			}
+			continue
+		}
+		s.resolvedProducts[ppID] = ref
+		s.follow(stackNameFromARN(ref), ref, pending.depth, pending.since)
+		delete(s.pendingProducts, ppID)
+	}
+}
+
+// followActiveChildren follows the children of t that are already in progress,
+// for when tail is started in the middle of an operation.
+func (s *tailSession) followActiveChildren(ctx context.Context, t *tailTarget) {
+	resources, err := listStackResources(ctx, s.client, t.ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to list resources for %s: %v\n", t.name, err)
+		return
+	}
+	for _, r := range resources {
+		physicalID := getValue(r.PhysicalResourceId)
+		if physicalID == "" || !strings.HasSuffix(string(r.ResourceStatus), "_IN_PROGRESS") {
+			continue
+		}
+		switch getValue(r.ResourceType) {
+		case "AWS::CloudFormation::Stack":
+			child := s.follow(stackNameFromARN(physicalID), physicalID, t.depth+1, time.Time{})
+			if _, err := s.seed(ctx, child); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to get events for %s: %v\n", child.name, err)
+			}
+			s.followActiveChildren(ctx, child)
+		case "AWS::ServiceCatalog::CloudFormationProvisionedProduct":
+			s.pendingProducts[physicalID] = pendingProduct{depth: t.depth + 1, since: time.Now()}
+		}
+	}
+	s.resolveProducts(ctx)
+}
+
+// showStack reports whether events come from more than one stack and need a
+// STACK column.
+func (s *tailSession) showStack() bool {
+	return s.opts.recursive || s.opts.matching()
+}
+
+func (s *tailSession) printHeader() {
+	if noHeaders || s.opts.output == "jsonl" {
+		return
+	}
+	if s.showStack() {
+		fmt.Printf("%-22s %-30s %-40s %-45s %-30s %s\n", "TIMESTAMP", "STACK", "LOGICAL ID", "TYPE", "STATUS", "REASON")
+		fmt.Printf("%-22s %-30s %-40s %-45s %-30s %s\n",
+			"──────────────────────", "──────────────────────────────", "────────────────────────────────────────",
+			"─────────────────────────────────────────────", "──────────────────────────────", "──────")
+		return
+	}
+	fmt.Printf("%-22s %-40s %-45s %-30s %s\n", "TIMESTAMP", "LOGICAL ID", "TYPE", "STATUS", "REASON")
+	fmt.Printf("%-22s %-40s %-45s %-30s %s\n",
+		"──────────────────────", "────────────────────────────────────────",
+		"─────────────────────────────────────────────", "──────────────────────────────", "──────")
+}
+
+func (s *tailSession) print(te tailEvent) {
+	e := te.event
+	if s.opts.output == "jsonl" {
+		writeJSONLine(os.Stdout, e)
+		return
+	}
+	ts := ""
+	if e.Timestamp != nil {
+		ts = e.Timestamp.Format("2006-01-02 15:04:05")
+	}
+	if s.showStack() {
+		stack := fmt.Sprintf("%-30s", truncate(strings.Repeat("  ", te.target.depth)+te.target.name, 30))
+		fmt.Printf("%-22s %s %-40s %-45s %-30s %s\n",
+			ts,
+			colorize(stack, stackColor(te.target.name)),
+			truncate(getValue(e.LogicalResourceId), 40),
+			truncate(getValue(e.ResourceType), 45),
+			truncate(eventStatus(e), 30),
+			eventReason(e),
+		)
+		return
+	}
+	fmt.Printf("%-22s %-40s %-45s %-30s %s\n",
+		ts,
+		truncate(getValue(e.LogicalResourceId), 40),
+		truncate(getValue(e.ResourceType), 45),
+		truncate(eventStatus(e), 30),
+		eventReason(e),
+	)
+}
+
+// finishTail prints the operation summary to w and exits non-zero unless the
+// stack finished in a successful status.
+func finishTail(w io.Writer, stackName string, status types.StackStatus, events []types.StackEvent) {
+	summary := summarizeOperation(events)
+	summary.Status = status
+
+	fmt.Fprintf(w, "\nStack %q finished: %s\n", stackName, colorize(string(status), colorForCFStatus(string(status))))
+	if !summary.Start.IsZero() && !summary.End.IsZero() {
+		fmt.Fprintf(w, "  Duration:          %s\n", summary.End.Sub(summary.Start).Round(time.Second))
+	}
+	fmt.Fprintf(w, "  Resources changed: %d\n", summary.Changed)
+	if summary.FailedResource != "" {
+		fmt.Fprintf(w, "  First failure:     %s — %s\n", summary.FailedResource, summary.FailureReason)
+	}
+	for _, h := range summary.FailedHooks {
+		fmt.Fprintf(w, "  Failed hook:       %s on %s (%s, %s) — %s\n", h.HookType, h.LogicalID, h.InvocationPoint, h.FailureMode, h.Reason)
+	}
+
+	if !isSuccessfulStackStatus(status) {
+		os.Exit(1)
+	}
+}
+
+// operationSummary describes the most recent stack operation found in an
+// event history.
+type operationSummary struct {
+	Status         types.StackStatus
+	Start          time.Time
+	End            time.Time
+	Changed        int
+	FailedResource string
+	FailureReason  string
+	FailedHooks    []hookInvocation
+}
+
+// operationStartStatuses are the stack-level statuses that open a new
+// operation. Rollback statuses belong to the operation that triggered them.
+var operationStartStatuses = map[types.ResourceStatus]bool{
+	types.ResourceStatusCreateInProgress: true,
+	types.ResourceStatusUpdateInProgress: true,
+	types.ResourceStatusDeleteInProgress: true,
+	types.ResourceStatusImportInProgress: true,
+}
+
+// summarizeOperation summarizes the latest operation in events, which must be
+// ordered newest-first as returned by DescribeStackEvents.
+func summarizeOperation(events []types.StackEvent) operationSummary {
+	ops := splitOperations(events)
+	if len(ops) == 0 {
+		return operationSummary{}
+	}
+	return summarizeEvents(ops[0])
+}
+
+// splitOperations groups a newest-first event history by stack operation.
+// Operations are returned newest first, each one ordered newest-first and
+// ending with the stack event that started it.
+func splitOperations(events []types.StackEvent) [][]types.StackEvent {
+	var ops [][]types.StackEvent
+	var current []types.StackEvent
+	for _, e := range events {
+		current = append(current, e)
+		if isStackEvent(e) && operationStartStatuses[e.ResourceStatus] {
+			ops = append(ops, current)
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		ops = append(ops, current)
+	}
+	return ops
+}
+
+// summarizeEvents summarizes the events of a single operation, newest-first.
+func summarizeEvents(events []types.StackEvent) operationSummary {
+	var summary operationSummary
+
+	changed := make(map[string]struct{})
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.Timestamp != nil {
+			if summary.Start.IsZero() {
+				summary.Start = *e.Timestamp
+			}
+			summary.End = *e.Timestamp
+		}
+		if isStackEvent(e) {
+			summary.Status = types.StackStatus(e.ResourceStatus)
+			continue
+		}
+		status := string(e.ResourceStatus)
+		switch {
+		case strings.HasSuffix(status, "_COMPLETE"):
+			changed[getValue(e.LogicalResourceId)] = struct{}{}
+		case strings.HasSuffix(status, "_FAILED") && summary.FailedResource == "":
+			summary.FailedResource = getValue(e.LogicalResourceId)
+			summary.FailureReason = getValue(e.ResourceStatusReason)
+		}
+	}
+	summary.Changed = len(changed)
+
+	for _, h := range summarizeHooks(events) {
+		if h.failed() {
+			summary.FailedHooks = append(summary.FailedHooks, h)
+		}
+	}
+
+	return summary
+}
+
+// isStackEvent reports whether e describes the stack itself rather than one of
+// its resources. Stack-level hook invocations are not stack events: they carry
+// no stack status.
+func isStackEvent(e types.StackEvent) bool {
+	return !isHookEvent(e) &&
+		getValue(e.ResourceType) == "AWS::CloudFormation::Stack" &&
+		getValue(e.PhysicalResourceId) == getValue(e.StackId)
 }
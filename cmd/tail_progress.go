@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// maxStuckLines caps how many stuck resources the footer lists.
+const maxStuckLines = 5
+
+// defaultStuckAfter is how long a resource can be in progress before it is
+// reported as stuck.
+const defaultStuckAfter = 10 * time.Minute
+
+// progressTracker follows the resources touched by the current operation of a
+// stack. It backs the tail status footer and the stuck-resource warnings.
+type progressTracker struct {
+	total     int // resources in the template, 0 if unknown
+	start     time.Time
+	end       time.Time // zero while the operation is running
+	status    types.StackStatus
+	previous  time.Duration // duration of the last successful operation
+	restarted bool          // a new operation started since total was counted
+	resources map[string]*resourceProgress
+}
+
+type resourceProgress struct {
+	logicalID    string
+	resourceType string
+	status       types.ResourceStatus
+	since        time.Time
+	warned       bool
+	notified     bool
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{resources: make(map[string]*resourceProgress)}
+}
+
+// load replays a newest-first event history: the latest operation becomes the
+// current one and the last successful operation before it, if loaded, sets
+// the previous deployment duration.
+func (p *progressTracker) load(events []types.StackEvent) {
+	ops := splitOperations(events)
+	if len(ops) == 0 {
+		return
+	}
+	for _, op := range ops[1:] {
+		summary := summarizeEvents(op)
+		if isSuccessfulStackStatus(summary.Status) {
+			p.previous = summary.End.Sub(summary.Start)
+			break
+		}
+	}
+	for i := len(ops[0]) - 1; i >= 0; i-- {
+		p.observe(ops[0][i])
+	}
+}
+
+// observe records a single event. Events must be observed oldest first.
+func (p *progressTracker) observe(e types.StackEvent) {
+	if e.Timestamp == nil || isHookEvent(e) {
+		return
+	}
+	ts := *e.Timestamp
+
+	if isStackEvent(e) {
+		if operationStartStatuses[e.ResourceStatus] {
+			if !p.start.IsZero() && !p.end.IsZero() && isSuccessfulStackStatus(p.status) {
+				p.previous = p.end.Sub(p.start)
+			}
+			p.start = ts
+			p.resources = make(map[string]*resourceProgress)
+			p.restarted = true
+		}
+		p.status = types.StackStatus(e.ResourceStatus)
+		if isTerminalStackStatus(p.status) {
+			p.end = ts
+		} else {
+			p.end = time.Time{}
+		}
+		return
+	}
+
+	logicalID := getValue(e.LogicalResourceId)
+	r, ok := p.resources[logicalID]
+	if !ok {
+		r = &resourceProgress{logicalID: logicalID, resourceType: getValue(e.ResourceType)}
+		p.resources[logicalID] = r
+	}
+	if r.status != e.ResourceStatus {
+		r.status = e.ResourceStatus
+		r.since = ts
+		r.warned = false
+		r.notified = false
+	}
+}
+
+func (p *progressTracker) counts() (complete, inProgress, failed int) {
+	for _, r := range p.resources {
+		status := string(r.status)
+		switch {
+		case strings.HasSuffix(status, "_COMPLETE"), r.status == types.ResourceStatusDeleteSkipped:
+			complete++
+		case strings.HasSuffix(status, "_IN_PROGRESS"):
+			inProgress++
+		case strings.HasSuffix(status, "_FAILED"):
+			failed++
+		}
+	}
+	return complete, inProgress, failed
+}
+
+func (p *progressTracker) elapsed(now time.Time) time.Duration {
+	switch {
+	case p.start.IsZero():
+		return 0
+	case !p.end.IsZero():
+		return p.end.Sub(p.start)
+	default:
+		return now.Sub(p.start)
+	}
+}
+
+// stuck returns the resources that have been in progress for at least
+// threshold, longest first.
+func (p *progressTracker) stuck(now time.Time, threshold time.Duration) []*resourceProgress {
+	if threshold <= 0 {
+		return nil
+	}
+	var stuck []*resourceProgress
+	for _, r := range p.resources {
+		if strings.HasSuffix(string(r.status), "_IN_PROGRESS") && now.Sub(r.since) >= threshold {
+			stuck = append(stuck, r)
+		}
+	}
+	sort.Slice(stuck, func(i, j int) bool {
+		return stuck[i].since.Before(stuck[j].since)
+	})
+	return stuck
+}
+
+// footer renders the status footer: a progress line followed by one line per
+// stuck resource.
+func (p *progressTracker) footer(now time.Time, threshold time.Duration) []string {
+	complete, inProgress, failed := p.counts()
+	total := p.total
+	if seen := len(p.resources); seen > total {
+		total = seen
+	}
+
+	line := fmt.Sprintf("%s %d/%d complete, %d in progress, %d failed | elapsed %s",
+		progressBar(complete, total, 20), complete, total, inProgress, failed,
+		p.elapsed(now).Round(time.Second))
+	if p.previous > 0 {
+		line += fmt.Sprintf(" (previous deployment %s)", p.previous.Round(time.Second))
+	}
+	lines := []string{line}
+
+	stuck := p.stuck(now, threshold)
+	for i, r := range stuck {
+		if i == maxStuckLines {
+			lines = append(lines, fmt.Sprintf("  ... and %d more in progress for over %s", len(stuck)-i, threshold))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  stuck? %s (%s) %s for %s",
+			r.logicalID, r.resourceType, r.status, now.Sub(r.since).Round(time.Second)))
+	}
+	return lines
+}
+
+func progressBar(done, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = min(done*width/total, width)
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+// loadProgress seeds the tracker of t with the events of the current and
+// previous operations and the template resource count.
+func (s *tailSession) loadProgress(ctx context.Context, t *tailTarget) {
+	events, err := listRecentOperations(ctx, s.client, t.ref, 2)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to load event history for %s: %v\n", t.name, err)
+		return
+	}
+	t.progress.load(events)
+	s.countResources(ctx, t)
+}
+
+// countResources sets the footer total from the resources in the stack's
+// template.
+func (s *tailSession) countResources(ctx context.Context, t *tailTarget) {
+	t.progress.restarted = false
+	out, err := s.client.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &t.ref,
+		TemplateStage: types.TemplateStageProcessed,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to get template for %s: %v\n", t.name, err)
+		return
+	}
+	template, err := parseTemplateBody(getValue(out.TemplateBody))
+	if err != nil {
+		return
+	}
+	if resources, ok := template["Resources"].(map[string]interface{}); ok {
+		t.progress.total = len(resources)
+	}
+}
+
+// footerEnabled reports whether the live footer is drawn. It needs a single
+// stack and an interactive terminal to redraw in place.
+func (s *tailSession) footerEnabled() bool {
+	return s.opts.progress && !s.opts.matching() && s.opts.output != "jsonl" && isTTY()
+}
+
+func (s *tailSession) drawFooter(t *tailTarget) {
+	lines := t.progress.footer(time.Now(), s.opts.stuckAfter)
+	for i, line := range lines {
+		if i > 0 {
+			line = colorize(line, colorYellow)
+		}
+		fmt.Println(line)
+	}
+	s.footerLines = len(lines)
+}
+
+// clearFooter erases the footer so events can be printed in its place.
+func (s *tailSession) clearFooter() {
+	for ; s.footerLines > 0; s.footerLines-- {
+		fmt.Print("\033[1A\033[2K")
+	}
+}
+
+// warnStuck reports each stuck resource once, for when there is no footer to
+// show them in.
+func (s *tailSession) warnStuck() {
+	now := time.Now()
+	for _, t := range s.targets {
+		if t.done {
+			continue
+		}
+		for _, r := range t.progress.stuck(now, s.opts.stuckAfter) {
+			if r.warned {
+				continue
+			}
+			r.warned = true
+			msg := fmt.Sprintf("warning: %s: %s (%s) has been %s for %s\n",
+				t.name, r.logicalID, r.resourceType, r.status, now.Sub(r.since).Round(time.Second))
+			fmt.Fprint(os.Stderr, colorize(msg, colorYellow))
+		}
+	}
+}
+
+// notifyStuck sends one notification per stuck resource.
+func (s *tailSession) notifyStuck(ctx context.Context) {
+	for _, t := range s.targets {
+		if !t.done {
+			s.notifier.stuckResources(ctx, t.name, t.progress, s.opts.stuckAfter)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestProgressTrackerLoad(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	// Newest first: a running update after a successful 6 minute update.
+	events := []types.StackEvent{
+		resourceEvent("Service", types.ResourceStatusUpdateInProgress, "", t0.Add(2*time.Minute)),
+		resourceEvent("Queue", types.ResourceStatusUpdateFailed, "denied", t0.Add(90*time.Second)),
+		resourceEvent("Bucket", types.ResourceStatusUpdateComplete, "", t0.Add(time.Minute)),
+		resourceEvent("Bucket", types.ResourceStatusUpdateInProgress, "", t0.Add(30*time.Second)),
+		stackEvent(types.ResourceStatusUpdateInProgress, t0),
+		stackEvent(types.ResourceStatusUpdateComplete, t0.Add(-time.Hour+6*time.Minute)),
+		stackEvent(types.ResourceStatusUpdateInProgress, t0.Add(-time.Hour)),
+	}
+
+	p := newProgressTracker()
+	p.load(events)
+
+	complete, inProgress, failed := p.counts()
+	if complete != 1 || inProgress != 1 || failed != 1 {
+		t.Errorf("counts = %d/%d/%d, want 1/1/1", complete, inProgress, failed)
+	}
+	if p.previous != 6*time.Minute {
+		t.Errorf("previous = %s, want 6m", p.previous)
+	}
+	if got := p.elapsed(t0.Add(3 * time.Minute)); got != 3*time.Minute {
+		t.Errorf("elapsed = %s, want 3m", got)
+	}
+}
+
+func TestProgressTrackerRestart(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	p := newProgressTracker()
+	p.observe(stackEvent(types.ResourceStatusUpdateInProgress, t0))
+	p.observe(resourceEvent("Bucket", types.ResourceStatusUpdateComplete, "", t0.Add(time.Minute)))
+	p.observe(stackEvent(types.ResourceStatusUpdateComplete, t0.Add(4*time.Minute)))
+
+	if got := p.elapsed(t0.Add(time.Hour)); got != 4*time.Minute {
+		t.Errorf("elapsed after completion = %s, want 4m", got)
+	}
+
+	p.restarted = false
+	p.observe(stackEvent(types.ResourceStatusUpdateInProgress, t0.Add(time.Hour)))
+	if !p.restarted {
+		t.Error("expected a new operation to mark the tracker as restarted")
+	}
+	if p.previous != 4*time.Minute {
+		t.Errorf("previous = %s, want 4m", p.previous)
+	}
+	if len(p.resources) != 0 {
+		t.Errorf("expected resources to reset, got %d", len(p.resources))
+	}
+}
+
+func TestProgressTrackerStuck(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	p := newProgressTracker()
+	p.observe(stackEvent(types.ResourceStatusCreateInProgress, t0))
+	p.observe(resourceEvent("Custom", types.ResourceStatusCreateInProgress, "", t0))
+	// A repeated in-progress event must not reset the clock.
+	p.observe(resourceEvent("Custom", types.ResourceStatusCreateInProgress, "Resource creation Initiated", t0.Add(time.Minute)))
+	p.observe(resourceEvent("Fast", types.ResourceStatusCreateInProgress, "", t0.Add(14*time.Minute)))
+
+	stuck := p.stuck(t0.Add(15*time.Minute), 10*time.Minute)
+	if len(stuck) != 1 || stuck[0].logicalID != "Custom" {
+		t.Fatalf("stuck = %v, want [Custom]", stuck)
+	}
+	if p.stuck(t0.Add(15*time.Minute), 0) != nil {
+		t.Error("a zero threshold should disable stuck detection")
+	}
+
+	lines := p.footer(t0.Add(15*time.Minute), 10*time.Minute)
+	if len(lines) != 2 {
+		t.Fatalf("footer lines = %d, want 2: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "0/2 complete, 2 in progress, 0 failed") {
+		t.Errorf("unexpected progress line %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "Custom") || !strings.Contains(lines[1], "15m0s") {
+		t.Errorf("unexpected stuck line %q", lines[1])
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	if got := progressBar(5, 10, 10); got != "[█████░░░░░]" {
+		t.Errorf("progressBar(5, 10) = %q", got)
+	}
+	if got := progressBar(0, 0, 4); got != "[░░░░]" {
+		t.Errorf("progressBar(0, 0) = %q", got)
+	}
+	if got := progressBar(12, 10, 4); got != "[████]" {
+		t.Errorf("progressBar(12, 10) = %q", got)
+	}
+}
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+const testStackID = "arn:aws:cloudformation:eu-west-1:123456789012:stack/my-stack/abc"
+
+func stackEvent(status types.ResourceStatus, ts time.Time) types.StackEvent {
+	return types.StackEvent{
+		StackId:            strPtr(testStackID),
+		LogicalResourceId:  strPtr("my-stack"),
+		PhysicalResourceId: strPtr(testStackID),
+		ResourceType:       strPtr("AWS::CloudFormation::Stack"),
+		ResourceStatus:     status,
+		Timestamp:          &ts,
+	}
+}
+
+func resourceEvent(logicalID string, status types.ResourceStatus, reason string, ts time.Time) types.StackEvent {
+	return types.StackEvent{
+		StackId:              strPtr(testStackID),
+		LogicalResourceId:    strPtr(logicalID),
+		ResourceType:         strPtr("AWS::S3::Bucket"),
+		ResourceStatus:       status,
+		ResourceStatusReason: strPtr(reason),
+		Timestamp:            &ts,
+	}
+}
+
+func TestSummarizeOperation_Rollback(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	// Newest first, as returned by DescribeStackEvents.
+	events := []types.StackEvent{
+		stackEvent(types.ResourceStatusUpdateRollbackComplete, t0.Add(5*time.Minute)),
+		resourceEvent("Bucket", types.ResourceStatusUpdateComplete, "", t0.Add(4*time.Minute)),
+		stackEvent(types.ResourceStatusUpdateRollbackInProgress, t0.Add(3*time.Minute)),
+		resourceEvent("Queue", types.ResourceStatusUpdateFailed, "Resource handler returned message: cancelled", t0.Add(2*time.Minute)),
+		resourceEvent("Bucket", types.ResourceStatusUpdateFailed, "Access Denied", t0.Add(time.Minute)),
+		stackEvent(types.ResourceStatusUpdateInProgress, t0),
+		// Previous operation, must be ignored.
+		resourceEvent("Old", types.ResourceStatusCreateFailed, "old failure", t0.Add(-time.Hour)),
+		stackEvent(types.ResourceStatusCreateInProgress, t0.Add(-2*time.Hour)),
+	}
+
+	summary := summarizeOperation(events)
+
+	if summary.Status != types.StackStatusUpdateRollbackComplete {
+		t.Errorf("Status = %s, want UPDATE_ROLLBACK_COMPLETE", summary.Status)
+	}
+	if got := summary.End.Sub(summary.Start); got != 5*time.Minute {
+		t.Errorf("duration = %s, want 5m", got)
+	}
+	if summary.Changed != 1 {
+		t.Errorf("Changed = %d, want 1", summary.Changed)
+	}
+	if summary.FailedResource != "Bucket" || summary.FailureReason != "Access Denied" {
+		t.Errorf("first failure = %s (%s), want Bucket (Access Denied)", summary.FailedResource, summary.FailureReason)
+	}
+}
+
+func TestSummarizeOperation_Empty(t *testing.T) {
+	summary := summarizeOperation(nil)
+	if summary.Changed != 0 || summary.FailedResource != "" || !summary.Start.IsZero() {
+		t.Errorf("expected zero summary, got %+v", summary)
+	}
+}
+
+func TestIsStackEvent(t *testing.T) {
+	if !isStackEvent(stackEvent(types.ResourceStatusCreateComplete, time.Now())) {
+		t.Error("expected stack-level event")
+	}
+	nested := types.StackEvent{
+		StackId:            strPtr(testStackID),
+		PhysicalResourceId: strPtr("arn:aws:cloudformation:eu-west-1:123456789012:stack/child/def"),
+		ResourceType:       strPtr("AWS::CloudFormation::Stack"),
+	}
+	if isStackEvent(nested) {
+		t.Error("nested stack resource should not be a stack-level event")
+	}
+}
+
+func TestIsTerminalStackStatus(t *testing.T) {
+	terminal := []types.StackStatus{
+		types.StackStatusCreateComplete,
+		types.StackStatusUpdateRollbackComplete,
+		types.StackStatusUpdateRollbackFailed,
+		types.StackStatusDeleteFailed,
+	}
+	for _, s := range terminal {
+		if !isTerminalStackStatus(s) {
+			t.Errorf("%s should be terminal", s)
+		}
+	}
+	running := []types.StackStatus{
+		types.StackStatusUpdateInProgress,
+		types.StackStatusUpdateCompleteCleanupInProgress,
+		types.StackStatusReviewInProgress,
+	}
+	for _, s := range running {
+		if isTerminalStackStatus(s) {
+			t.Errorf("%s should not be terminal", s)
+		}
+	}
+}
+
+func TestIsSuccessfulStackStatus(t *testing.T) {
+	if !isSuccessfulStackStatus(types.StackStatusUpdateComplete) {
+		t.Error("UPDATE_COMPLETE should be successful")
+	}
+	if isSuccessfulStackStatus(types.StackStatusRollbackComplete) {
+		t.Error("ROLLBACK_COMPLETE should not be successful")
+	}
+	if isSuccessfulStackStatus(types.StackStatusUpdateRollbackComplete) {
+		t.Error("UPDATE_ROLLBACK_COMPLETE should not be successful")
+	}
+}
+
+func TestTailTargetUnseen(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	target := &tailTarget{since: t0, seen: map[string]struct{}{"e1": {}}}
+
+	withID := func(id string, ts time.Time) types.StackEvent {
+		e := resourceEvent(id, types.ResourceStatusCreateInProgress, "", ts)
+		e.EventId = strPtr(id)
+		return e
+	}
+
+	// Newest first.
+	events := []types.StackEvent{
+		withID("e4", t0.Add(2*time.Second)),
+		withID("e3", t0.Add(time.Second)),
+		withID("e2", t0),
+		withID("e1", t0),
+		withID("e0", t0.Add(-time.Second)),
+	}
+
+	fresh := target.unseen(events)
+	var ids []string
+	for _, e := range fresh {
+		ids = append(ids, getValue(e.EventId))
+	}
+	if want := "e2,e3,e4"; strings.Join(ids, ",") != want {
+		t.Errorf("unseen = %v, want %s", ids, want)
+	}
+	if !target.since.Equal(t0.Add(2 * time.Second)) {
+		t.Errorf("since = %s, want latest event timestamp", target.since)
+	}
+
+	if again := target.unseen(events); len(again) != 0 {
+		t.Errorf("expected no events on second call, got %d", len(again))
+	}
+}
+
+func TestStackNameFromARN(t *testing.T) {
+	if got := stackNameFromARN(testStackID); got != "my-stack" {
+		t.Errorf("stackNameFromARN(arn) = %q, want my-stack", got)
+	}
+	if got := stackNameFromARN("plain-name"); got != "plain-name" {
+		t.Errorf("stackNameFromARN(name) = %q, want plain-name", got)
+	}
+}
+
+func TestTailSessionFollowReactivates(t *testing.T) {
+	s := newTailSession(nil, tailOptions{recursive: true})
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	child := s.follow("child", "arn:child", 1, t0)
+	child.done = true
+
+	again := s.follow("child", "arn:child", 1, t0.Add(time.Minute))
+	if again != child {
+		t.Fatal("expected the existing target to be reused")
+	}
+	if child.done {
+		t.Error("expected finished child to be reactivated")
+	}
+	if !child.since.Equal(t0.Add(time.Minute)) {
+		t.Errorf("since = %s, want reactivation time", child.since)
+	}
+	if len(s.targets) != 1 {
+		t.Errorf("targets = %d, want 1", len(s.targets))
+	}
+}
+
+func TestTailSessionDiscoverFromFirstEvent(t *testing.T) {
+	s := newTailSession(nil, tailOptions{recursive: true})
+	parent := s.follow("parent", testStackID, 0, time.Time{})
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// CloudFormation logs the nested stack's first event, then the parent's
+	// event with its physical ID.
+	started := resourceEvent("Network", types.ResourceStatusCreateInProgress, "", t0)
+	started.ResourceType = strPtr("AWS::CloudFormation::Stack")
+	initiated := resourceEvent("Network", types.ResourceStatusCreateInProgress, "Resource creation Initiated", t0.Add(200*time.Millisecond))
+	initiated.ResourceType = strPtr("AWS::CloudFormation::Stack")
+	initiated.PhysicalResourceId = strPtr("arn:aws:cloudformation:us-east-1:123456789012:stack/parent-Network-1/abc")
+
+	s.discover(tailEvent{event: started, target: parent})
+	s.discover(tailEvent{event: initiated, target: parent})
+
+	child := s.byRef[getValue(initiated.PhysicalResourceId)]
+	if child == nil {
+		t.Fatal("nested stack not followed")
+	}
+	if !child.since.Equal(t0) {
+		t.Errorf("child since = %s, want the first in-progress event %s", child.since, t0)
+	}
+
+	complete := resourceEvent("Network", types.ResourceStatusCreateComplete, "", t0.Add(time.Minute))
+	s.discover(tailEvent{event: complete, target: parent})
+	if _, ok := parent.started["Network"]; ok {
+		t.Error("start of a finished resource still recorded")
+	}
+}
+
+func TestTailSessionResolveProducts(t *testing.T) {
+	s := newTailSession(nil, tailOptions{recursive: true})
+	s.sc = testServiceCatalog()
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	s.pendingProducts["pp-abc"] = pendingProduct{depth: 1, since: t0}
+	s.pendingProducts["pp-new"] = pendingProduct{depth: 1, since: t0}
+
+	s.resolveProducts(context.Background())
+
+	if len(s.targets) != 1 || s.targets[0].ref != testSCStackARN || s.targets[0].name != "SC-123456789012-pp-abc" {
+		t.Fatalf("targets = %+v, want the stack of pp-abc", s.targets)
+	}
+	if s.resolvedProducts["pp-abc"] != testSCStackARN {
+		t.Errorf("pp-abc not recorded as resolved")
+	}
+	// pp-new has no stack recorded yet and is retried on the next poll
+	if p, ok := s.pendingProducts["pp-new"]; !ok || !p.warned {
+		t.Errorf("pp-new pending = %+v, %v", p, ok)
+	}
+	if _, ok := s.pendingProducts["pp-abc"]; ok {
+		t.Error("pp-abc still pending")
+	}
+}
+
+func TestTailInfoStream(t *testing.T) {
+	if w := (tailOptions{output: "jsonl"}).info(); w != os.Stderr {
+		t.Error("with jsonl output, messages should go to stderr")
+	}
+	if w := (tailOptions{output: "table"}).info(); w != os.Stdout {
+		t.Error("with table output, messages should go to stdout")
+	}
+
+	// The final summary goes to the info stream, not to the JSON stream.
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := []types.StackEvent{
+		stackEvent(types.ResourceStatusCreateComplete, t0.Add(time.Minute)),
+		resourceEvent("Bucket", types.ResourceStatusCreateComplete, "", t0.Add(30*time.Second)),
+		stackEvent(types.ResourceStatusCreateInProgress, t0),
+	}
+	var info bytes.Buffer
+	finishTail(&info, "my-stack", types.StackStatusCreateComplete, events)
+	if !strings.Contains(info.String(), `Stack "my-stack" finished: CREATE_COMPLETE`) {
+		t.Errorf("summary = %q", info.String())
+	}
+}
@@ -31,6 +31,7 @@ func main() {
 		cmd.ListCmd(),
 		cmd.DeleteCmd(),
 		cmd.EventsCmd(),
+		cmd.HooksCmd(),
 		cmd.DescribeCmd(),
 		cmd.OutputsCmd(),
 		cmd.ParametersCmd(),
@@ -38,9 +39,12 @@ func main() {
 		cmd.DriftCmd(),
 		cmd.TailCmd(),
 		cmd.TemplateCmd(),
+		cmd.BackupCmd(),
+		cmd.RestoreCmd(),
 		cmd.ValidateCmd(),
 		cmd.ContinueRollbackCmd(),
 		cmd.FixCmd(),
+		cmd.ScCmd(),
 		cmd.GenDocsCmd(rootCmd),
 	)
 